@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"context"
+	"ewallet-topup/helpers"
+	"ewallet-topup/internal/workflow"
+	"ewallet-topup/internal/workflow/transaction"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.temporal.io/sdk/worker"
+	sdkworkflow "go.temporal.io/sdk/workflow"
+)
+
+// historyCorpus is where the recorded transaction workflow histories live, relative to the
+// repository root. Every deploy should pass: ewallet replay-workflow -dir <historyCorpus>
+const historyCorpus = "internal/workflow/transaction/testdata/histories"
+
+func newReplayer(c *Container) (worker.WorkflowReplayer, error) {
+	codec, err := workflow.NewEncryptionCodecFromConfig(c.Config.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	replayer, err := worker.NewWorkflowReplayerWithOptions(worker.WorkflowReplayerOptions{
+		DataConverter: workflow.NewDataConverter(codec),
+	})
+	if err != nil {
+		return nil, err
+	}
+	replayer.RegisterWorkflow(transaction.TransactionWorkflow)
+	return replayer, nil
+}
+
+func replayFlags(fs *flag.FlagSet) func(c *Container, args []string) error {
+	reference := fs.String("reference", "", "replay the workflow of this transaction, fetched from temporal")
+	runID := fs.String("run-id", "", "run id to replay, default the latest run")
+	file := fs.String("file", "", "replay a history exported with: temporal workflow show --output json")
+	dir := fs.String("dir", "", "replay every *.json history in this directory, e.g. "+historyCorpus)
+	return func(c *Container, _ []string) error {
+		set := 0
+		for _, v := range []string{*reference, *file, *dir} {
+			if v != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return usageError("replay-workflow needs exactly one of -reference, -file or -dir")
+		}
+
+		replayer, err := newReplayer(c)
+		if err != nil {
+			return err
+		}
+		logger := helpers.TemporalLogger(c.Logger)
+		switch {
+		case *dir != "":
+			return replayDir(c, replayer, *dir)
+		case *file != "":
+			err = replayer.ReplayWorkflowHistoryFromJSONFile(logger, *file)
+		default:
+			defer c.App.Shutdown()
+			tc, errDial := c.temporal()
+			if errDial != nil {
+				return errDial
+			}
+			err = replayer.ReplayWorkflowExecution(context.Background(), tc.WorkflowService(), logger, c.Config.Temporal.Namespace,
+				sdkworkflow.Execution{ID: workflow.TransactionWorkflowID(*reference), RunID: *runID})
+		}
+		if err != nil {
+			// a nondeterminism error here means the current code cannot resume this history
+			return fmt.Errorf("replay failed: %w", err)
+		}
+		c.Logger.Info("replay succeeded, the history is compatible with the current workflow code")
+		return nil
+	}
+}
+
+func replayDir(c *Container, replayer worker.WorkflowReplayer, dir string) error {
+	results, err := workflow.ReplayHistories(replayer, helpers.TemporalLogger(c.Logger), dir)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			c.Logger.Error("replay failed", "file", r.File, "error", r.Err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d histories failed to replay, gate the change with workflow.GetVersion", failed, len(results))
+	}
+	if len(results) == 0 {
+		c.Logger.Warn("no histories found, nothing was checked", "dir", dir)
+		return nil
+	}
+	c.Logger.Info("replay succeeded, every history is compatible with the current workflow code", "histories", len(results))
+	return nil
+}
+
+func exportHistoryFlags(fs *flag.FlagSet) func(c *Container, args []string) error {
+	reference := fs.String("reference", "", "export the workflow of this transaction")
+	runID := fs.String("run-id", "", "run id to export, default the latest run")
+	out := fs.String("out", historyCorpus, "directory the history is written to")
+	decode := fs.Bool("decode", false, "decrypt the payloads so the history replays without the encryption keys, "+
+		"the file then holds the user profile in clear text and must be reviewed before it is committed")
+	return func(c *Container, _ []string) error {
+		if *reference == "" {
+			return usageError("export-history needs -reference")
+		}
+		defer c.App.Shutdown()
+		tc, err := c.temporal()
+		if err != nil {
+			return err
+		}
+
+		var codec *workflow.EncryptionCodec
+		if *decode {
+			if codec, err = workflow.NewEncryptionCodecFromConfig(c.Config.Encryption); err != nil {
+				return err
+			}
+		}
+
+		ctx := context.Background()
+		workflowID := workflow.TransactionWorkflowID(*reference)
+		run := *runID
+		if run == "" {
+			desc, err := tc.DescribeWorkflowExecution(ctx, workflowID, "")
+			if err != nil {
+				return err
+			}
+			run = desc.GetWorkflowExecutionInfo().GetExecution().GetRunId()
+		}
+
+		path := filepath.Join(*out, workflowID+"_"+run+".json")
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err := workflow.ExportHistory(ctx, tc, workflowID, run, codec, f); err != nil {
+			f.Close()
+			os.Remove(path)
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		c.Logger.Info("history exported", "reference", *reference, "run_id", run, "file", path, "decoded", *decode)
+		return nil
+	}
+}
+
+func reconcileFlags(fs *flag.FlagSet) func(c *Container, args []string) error {
+	since := fs.Duration("since", 24*time.Hour, "check transactions created within this window")
+	limit := fs.Int("limit", 1000, "check at most this many transactions")
+	return func(c *Container, _ []string) error {
+		defer c.App.Shutdown()
+		svc, err := c.maintenanceService()
+		if err != nil {
+			return err
+		}
+
+		mismatches, checked, err := svc.Reconcile(context.Background(), *since, *limit)
+		if err != nil {
+			return err
+		}
+		for _, m := range mismatches {
+			c.Logger.Warn("reconcile mismatch", "reference", m.Reference, "status", m.Status,
+				"workflow_status", m.WorkflowStatus, "problem", m.Problem)
+		}
+		c.Logger.Info("reconcile finished", "checked", checked, "mismatches", len(mismatches))
+		if len(mismatches) > 0 {
+			return errFindings
+		}
+		return nil
+	}
+}
+
+func sweepFlags(fs *flag.FlagSet) func(c *Container, args []string) error {
+	olderThan := fs.Duration("older-than", workflow.PendingTransactionTimeout+time.Hour, "only sweep transactions pending for longer than this")
+	limit := fs.Int("limit", 100, "sweep at most this many transactions")
+	dryRun := fs.Bool("dry-run", false, "only log what would be swept")
+	return func(c *Container, _ []string) error {
+		if *olderThan < workflow.PendingTransactionTimeout {
+			return usageError(fmt.Sprintf("-older-than must be at least %s, younger transactions may still be confirmed", workflow.PendingTransactionTimeout))
+		}
+		defer c.App.Shutdown()
+		svc, err := c.maintenanceService()
+		if err != nil {
+			return err
+		}
+
+		result, err := svc.SweepStuck(context.Background(), *olderThan, *limit, *dryRun)
+		if err != nil {
+			return err
+		}
+		c.Logger.Info("sweep finished", "checked", result.Checked, "swept", result.Swept,
+			"review", result.Review, "dry_run", *dryRun)
+		if len(result.Review) > 0 {
+			return errFindings
+		}
+		return nil
+	}
+}
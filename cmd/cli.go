@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"errors"
+	"ewallet-topup/helpers"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Exit codes, so a kubernetes job can tell a retryable failure from a broken invocation.
+const (
+	ExitOK = 0
+	// ExitFailure means the command ran and failed, running it again may succeed
+	ExitFailure = 1
+	// ExitUsage means bad flags, arguments or config, running it again will not help
+	ExitUsage = 2
+	// ExitFindings means the command worked but found something that needs attention,
+	// e.g. reconcile mismatches or pending migrations
+	ExitFindings = 3
+)
+
+// errFindings is returned by commands that completed but want ExitFindings.
+var errFindings = errors.New("findings reported")
+
+// usageError is a mistake in the command arguments, reported with ExitUsage.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	// process names the process in config, e.g. the tracing service name suffix
+	process string
+	// drain waits SHUTDOWN_DRAIN_DELAY on shutdown, for servers behind a load balancer
+	drain bool
+	// flags registers the command flags and returns the function running the command
+	flags func(fs *flag.FlagSet) func(c *Container, args []string) error
+}
+
+func commands() []command {
+	return []command{
+		{name: "serve-api", summary: "serve the http api", process: "api", drain: true, flags: serveAPIFlags},
+		{name: "serve-grpc", summary: "serve the grpc api", process: "grpc", drain: true, flags: serveGRPCFlags},
+		{name: "worker", summary: "run the temporal worker", process: "worker", flags: workerFlags},
+		{name: "sandbox", summary: "run the api and worker against in-process fakes, needs only temporal", process: "sandbox", flags: sandboxFlags},
+		{name: "migrate", args: "up|down|status", summary: "apply, revert or list the versioned sql migrations", process: "migrate", flags: migrateFlags},
+		{name: "replay-workflow", summary: "replay a transaction workflow history against the current code", process: "admin", flags: replayFlags},
+		{name: "export-history", summary: "export a transaction workflow history into the replay corpus", process: "admin", flags: exportHistoryFlags},
+		{name: "reconcile", summary: "report transactions that disagree with their workflow", process: "admin", flags: reconcileFlags},
+		{name: "sweep-stuck", summary: "fail pending transactions whose workflow is gone", process: "admin", flags: sweepFlags},
+	}
+}
+
+// Main runs the command named by args[0] and returns the process exit code.
+func Main(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		printUsage(os.Stdout)
+		if len(args) == 0 {
+			return ExitUsage
+		}
+		return ExitOK
+	}
+
+	for _, c := range commands() {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	printUsage(os.Stderr)
+	return ExitUsage
+}
+
+func (c command) run(args []string) int {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	var cfgFlags helpers.ConfigFlags
+	cfgFlags.Register(fs)
+	run := c.flags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s\n\n%s\n\nflags:\n", strings.TrimSpace("ewallet "+c.name+" [flags] "+c.args), c.summary)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+
+	cfg, logger, ok := loadConfig(c.process, cfgFlags)
+	if !ok {
+		return ExitUsage
+	}
+
+	err := run(NewContainer(cfg, logger, c.drain), fs.Args())
+	var usage usageError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, errFindings):
+		return ExitFindings
+	case errors.As(err, &usage):
+		fmt.Fprintf(os.Stderr, "%s\n\n", usage)
+		fs.Usage()
+		return ExitUsage
+	default:
+		logger.Error(c.name+" failed", "error", err)
+		return ExitFailure
+	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: ewallet <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %-16s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "every command accepts -profile, -config and -set KEY=VALUE, see ewallet <command> -h.")
+	fmt.Fprintf(w, "exit codes: %d ok, %d failed, %d usage or config error, %d findings reported\n",
+		ExitOK, ExitFailure, ExitUsage, ExitFindings)
+}
+
+// oneOf checks a positional argument against the allowed values.
+func oneOf(arg string, allowed ...string) error {
+	for _, a := range allowed {
+		if arg == a {
+			return nil
+		}
+	}
+	return usageError(fmt.Sprintf("expected one of %s, got %q", strings.Join(allowed, ", "), arg))
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"ewallet-topup/internal/config"
+	"ewallet-topup/internal/workflow"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.temporal.io/sdk/converter"
+)
+
+// registerCodecServer exposes /codec/encode and /codec/decode so the temporal web ui
+// can show encrypted payloads. Both sit behind auth since decode decrypts history; keep it on
+// an internal network too. The ui has to send the token, e.g. with codec passAccessToken.
+func registerCodecServer(r *gin.Engine, cfg *config.Config, logger *slog.Logger, auth gin.HandlerFunc) error {
+	if !cfg.Temporal.CodecServerEnabled {
+		return nil
+	}
+
+	codec, err := workflow.NewEncryptionCodecFromConfig(cfg.Encryption)
+	if err != nil {
+		return fmt.Errorf("failed to load payload encryption codec: %w", err)
+	}
+	if codec == nil {
+		logger.Warn("codec server enabled but no payload encryption key configured")
+		return nil
+	}
+
+	handler := gin.WrapH(converter.NewPayloadCodecHTTPHandler(codec))
+	allowedOrigin := cfg.Temporal.UIOrigin
+
+	codecGroup := r.Group("/codec", func(c *gin.Context) {
+		c.Header("Access-Control-Allow-Origin", allowedOrigin)
+		c.Header("Access-Control-Allow-Methods", "POST, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, X-Namespace, Authorization")
+		c.Header("Access-Control-Allow-Credentials", "true")
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	})
+	// preflight requests carry no credentials, so only the codec calls need auth
+	codecGroup.OPTIONS("/*path")
+	codecGroup.POST("/encode", auth, handler)
+	codecGroup.POST("/decode", auth, handler)
+	return nil
+}
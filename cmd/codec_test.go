@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"ewallet-topup/internal/config"
+	"ewallet-topup/internal/fakes"
+	"ewallet-topup/internal/models"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCodecServerAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ext := fakes.NewExternal()
+	ext.AddUser("token-7", models.TokenData{UserID: 7}, 0)
+	d := &Dependency{Logger: logger, TokenVerifier: ext}
+
+	cfg := &config.Config{}
+	cfg.Temporal.CodecServerEnabled = true
+	cfg.Encryption.Keys = "k1:" + base64.StdEncoding.EncodeToString(make([]byte, 32))
+	cfg.Encryption.ActiveKeyID = "k1"
+
+	r := gin.New()
+	if err := registerCodecServer(r, cfg, logger, d.MiddlewareValidateToken); err != nil {
+		t.Fatal(err)
+	}
+	send := func(method, path, auth string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"payloads":[]}`))
+		req.Header.Set("Content-Type", "application/json")
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		auth   string
+		want   int
+	}{
+		{"decode without token", http.MethodPost, "/codec/decode", "", http.StatusUnauthorized},
+		{"encode without token", http.MethodPost, "/codec/encode", "", http.StatusUnauthorized},
+		{"decode with unknown token", http.MethodPost, "/codec/decode", "Bearer nope", http.StatusUnauthorized},
+		{"decode", http.MethodPost, "/codec/decode", "Bearer token-7", http.StatusOK},
+		{"preflight", http.MethodOptions, "/codec/decode", "", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := send(tt.method, tt.path, tt.auth); code != tt.want {
+				t.Errorf("status = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
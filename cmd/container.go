@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"context"
+	"ewallet-topup/external"
+	"ewallet-topup/helpers"
+	"ewallet-topup/internal/config"
+	"ewallet-topup/internal/interfaces"
+	"ewallet-topup/internal/lifecycle"
+	"ewallet-topup/internal/metrics"
+	"ewallet-topup/internal/migrations"
+	"ewallet-topup/internal/repository"
+	"ewallet-topup/internal/services"
+	"ewallet-topup/internal/tracing"
+	"ewallet-topup/internal/workflow"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/interceptor"
+	"gorm.io/gorm"
+)
+
+// Container is the composition root of every command. Each component is built from config
+// the first time it is asked for, its cleanup is registered with App, and later calls return
+// the same instance, so the api, the worker and the admin commands are wired the same way.
+// Setting a field before first use swaps the component, e.g. a fake temporal client in tests.
+type Container struct {
+	Config *config.Config
+	App    *lifecycle.App
+	Logger *slog.Logger
+
+	DB                 *gorm.DB
+	DBPools            helpers.DBPools
+	Temporal           client.Client
+	External           interfaces.IExternal
+	TokenVerifier      interfaces.ITokenVerifier
+	TransactionRepo    interfaces.ITransactionRepo
+	TransactionService interfaces.ITransactionService
+}
+
+// NewContainer creates the lifecycle app of a command. drain keeps serving for the drain
+// delay after readiness turns false, only processes behind a load balancer need it.
+func NewContainer(cfg *config.Config, logger *slog.Logger, drain bool) *Container {
+	lifecycleCfg := cfg.Shutdown
+	if !drain {
+		lifecycleCfg.DrainDelay = 0
+	}
+	return &Container{
+		Config: cfg,
+		App:    lifecycle.New(lifecycleCfg, logger),
+		Logger: logger,
+	}
+}
+
+// loadConfig loads and validates the config and builds the logger. Problems go to stderr
+// as plain text since the logger is not configured yet.
+func loadConfig(process string, flags helpers.ConfigFlags) (*config.Config, *slog.Logger, bool) {
+	cfg, err := config.Load(process, flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, nil, false
+	}
+	logger := helpers.NewLogger(cfg.Log, os.Stdout)
+	// for libraries logging through the slog default
+	slog.SetDefault(logger)
+	logger.Info("logger initiated", "log_level", cfg.Log.Level.String())
+	return cfg, logger, true
+}
+
+// setupTracing installs the tracer provider and flushes it as the very last closer.
+func (c *Container) setupTracing() error {
+	shutdownTracing, err := tracing.Setup(context.Background(), c.Config.Tracing)
+	if err != nil {
+		return err
+	}
+	c.App.Close("tracing", shutdownTracing)
+	return nil
+}
+
+// connectDB opens the primary and the replicas without looking at the schema, the migrate
+// command uses it directly.
+func (c *Container) connectDB(dbCfg helpers.DBConfig) (*gorm.DB, error) {
+	if c.DB != nil {
+		return c.DB, nil
+	}
+	db, pools, err := helpers.SetupDB(dbCfg, c.Logger)
+	if err != nil {
+		return nil, err
+	}
+	c.App.Close("database", func(context.Context) error { return pools.Close() })
+	for _, pool := range pools {
+		metrics.RegisterDBPool(pool.Name, pool.DB)
+	}
+	c.DB, c.DBPools = db, pools
+	return db, nil
+}
+
+// db connects and refuses to continue on an outdated schema, applying the pending
+// migrations first when DB_AUTO_MIGRATE is on. The pool stats of the primary and every
+// replica are exported as metrics.
+func (c *Container) db() (*gorm.DB, error) {
+	if c.DB != nil {
+		return c.DB, nil
+	}
+	db, err := c.connectDB(c.Config.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := c.migrator(db)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if c.Config.DB.AutoMigrate {
+		if _, err := migrator.Up(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if err := migrator.Check(ctx); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (c *Container) migrator(db *gorm.DB) (*migrations.Migrator, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	return migrations.NewMigrator(sqlDB, c.Config.DB.Driver, c.Logger)
+}
+
+func (c *Container) temporal() (client.Client, error) {
+	if c.Temporal != nil {
+		return c.Temporal, nil
+	}
+	codec, err := workflow.NewEncryptionCodecFromConfig(c.Config.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	tc, err := client.Dial(client.Options{
+		HostPort:       c.Config.Temporal.Host,
+		Namespace:      c.Config.Temporal.Namespace,
+		DataConverter:  workflow.NewDataConverter(codec),
+		MetricsHandler: metrics.NewTemporalHandler(),
+		Interceptors:   []interceptor.ClientInterceptor{tracing.NewTemporalInterceptor()},
+		Logger:         helpers.TemporalLogger(c.Logger),
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.App.Close("temporal", func(context.Context) error { tc.Close(); return nil })
+	c.Temporal = tc
+	return tc, nil
+}
+
+func (c *Container) external() (interfaces.IExternal, error) {
+	if c.External != nil {
+		return c.External, nil
+	}
+	ext, err := external.NewExternal(c.Config.External, c.Logger)
+	if err != nil {
+		return nil, err
+	}
+	c.App.Close("external", func(context.Context) error { return ext.Close() })
+	c.External = ext
+	return ext, nil
+}
+
+// tokenVerifier picks how bearer tokens are checked, AUTH_MODE=ums (default) or jwt.
+func (c *Container) tokenVerifier() (interfaces.ITokenVerifier, error) {
+	if c.TokenVerifier != nil {
+		return c.TokenVerifier, nil
+	}
+	switch c.Config.Auth.Mode {
+	case config.AuthModeUMS:
+		ext, err := c.external()
+		if err != nil {
+			return nil, err
+		}
+		c.TokenVerifier = ext
+	case config.AuthModeJWT:
+		verifier, err := external.NewJWTVerifier(c.Config.External.JWT, c.Logger)
+		if err != nil {
+			return nil, err
+		}
+		c.App.Close("jwt_verifier", func(context.Context) error { verifier.Close(); return nil })
+		c.TokenVerifier = verifier
+	default:
+		return nil, fmt.Errorf("unknown AUTH_MODE %q", c.Config.Auth.Mode)
+	}
+	return c.TokenVerifier, nil
+}
+
+func (c *Container) transactionRepo() (interfaces.ITransactionRepo, error) {
+	if c.TransactionRepo != nil {
+		return c.TransactionRepo, nil
+	}
+	db, err := c.db()
+	if err != nil {
+		return nil, err
+	}
+	c.TransactionRepo = &repository.TransactionRepo{DB: db}
+	return c.TransactionRepo, nil
+}
+
+func (c *Container) transactionService() (interfaces.ITransactionService, error) {
+	if c.TransactionService != nil {
+		return c.TransactionService, nil
+	}
+	repo, err := c.transactionRepo()
+	if err != nil {
+		return nil, err
+	}
+	ext, err := c.external()
+	if err != nil {
+		return nil, err
+	}
+	c.TransactionService = services.NewTransactionService(repo, ext, c.Logger)
+	return c.TransactionService, nil
+}
+
+func (c *Container) maintenanceService() (*services.MaintenanceService, error) {
+	repo, err := c.transactionRepo()
+	if err != nil {
+		return nil, err
+	}
+	svc, err := c.transactionService()
+	if err != nil {
+		return nil, err
+	}
+	tc, err := c.temporal()
+	if err != nil {
+		return nil, err
+	}
+	return &services.MaintenanceService{
+		TransactionRepo:    repo,
+		TransactionService: svc,
+		Temporal:           tc,
+		Logger:             c.Logger,
+	}, nil
+}
+
+// healthcheck starts the background readiness checks of the api and stops them on shutdown.
+func (c *Container) healthcheck() (*services.Healthcheck, error) {
+	db, err := c.db()
+	if err != nil {
+		return nil, err
+	}
+	ext, err := c.external()
+	if err != nil {
+		return nil, err
+	}
+	tc, err := c.temporal()
+	if err != nil {
+		return nil, err
+	}
+
+	svc := services.NewHealthcheck(&repository.HealthcheckRepo{DB: db, Pools: c.DBPools}, ext, tc, c.Config.Health)
+	ctx, stop := context.WithCancel(context.Background())
+	svc.Start(ctx)
+	c.App.OnShutdown(svc.MarkDraining)
+	c.App.Close("healthcheck", func(context.Context) error { stop(); return nil })
+	return svc, nil
+}
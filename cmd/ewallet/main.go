@@ -0,0 +1,10 @@
+package main
+
+import (
+	"ewallet-topup/cmd"
+	"os"
+)
+
+func main() {
+	os.Exit(cmd.Main(os.Args[1:]))
+}
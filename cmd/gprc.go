@@ -1,27 +1,53 @@
 package cmd
 
 import (
-	"ewallet-topup/helpers"
-	"log"
+	"context"
+	"ewallet-topup/internal/lifecycle"
+	"fmt"
 	"net"
 
-	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 )
 
-func ServeGRPC() {
-	lis, err := net.Listen("tcp", ":"+helpers.GetEnv("GRPC_PORT", "7000"))
+// ServeGRPC registers the grpc server with app. On shutdown it stops accepting calls and
+// waits for running ones, falling back to a hard stop when the deadline passes.
+func ServeGRPC(c *Container) error {
+	cfg := c.Config.GRPCServer
+	creds, err := cfg.TLS.ServerCredentials(c.Logger)
 	if err != nil {
-		log.Fatal("failed to listen grpc port: ", err)
+		return fmt.Errorf("failed to load grpc server tls credentials: %w", err)
 	}
 
-	s := grpc.NewServer()
+	lis, err := net.Listen("tcp", ":"+cfg.Port)
+	if err != nil {
+		return fmt.Errorf("failed to listen grpc port: %w", err)
+	}
+
+	s := grpc.NewServer(grpc.Creds(creds), grpc.StatsHandler(otelgrpc.NewServerHandler()))
 
 	// list method
 	// pb.ExampleMethod(s, &grpc....)
 
-	logrus.Info("start listening grpc on port:" + helpers.GetEnv("GRPC_PORT", "7000"))
-	if err := s.Serve(lis); err != nil {
-		log.Fatal("failed to serve grpc port: ", err)
-	}
+	c.App.Go(lifecycle.Service{
+		Name: "grpc",
+		Run: func() error {
+			c.Logger.Info("start listening grpc", "port", cfg.Port)
+			return s.Serve(lis)
+		},
+		Stop: func(ctx context.Context) error {
+			stopped := make(chan struct{})
+			go func() {
+				s.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				s.Stop()
+			}
+			return nil
+		},
+	})
+	return nil
 }
@@ -1,74 +1,125 @@
 package cmd
 
 import (
-	"ewallet-topup/external"
-	"ewallet-topup/helpers"
 	"ewallet-topup/internal/api"
 	"ewallet-topup/internal/interfaces"
-	"ewallet-topup/internal/repository"
+	"ewallet-topup/internal/lifecycle"
+	"ewallet-topup/internal/metrics"
 	"ewallet-topup/internal/services"
-	"log"
+	"ewallet-topup/internal/tracing"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.temporal.io/sdk/client"
 )
 
-func ServeHTTP(temporal client.Client) {
-	d := dependencyInject(temporal)
+// ServeHTTP builds the router and registers the server with the container app, which starts
+// it and drains it on shutdown. Readiness fails as soon as shutdown starts.
+func ServeHTTP(c *Container) error {
+	r, err := newRouter(c)
+	if err != nil {
+		return err
+	}
+	serveRouter(c, r)
+	return nil
+}
 
-	r := gin.Default()
+func serveRouter(c *Container, r *gin.Engine) {
+	c.App.Go(lifecycle.HTTPServer("http", &http.Server{
+		Addr:              ":" + c.Config.App.Port,
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+	}))
+}
+
+func newRouter(c *Container) (*gin.Engine, error) {
+	d, err := dependencyInject(c)
+	if err != nil {
+		return nil, err
+	}
+
+	r := gin.New()
+	r.Use(gin.CustomRecoveryWithWriter(io.Discard, d.recoverHandler))
+	r.Use(metrics.GinMiddleware())
+	r.Use(tracing.GinMiddleware(c.Config.Tracing.ServiceName)...)
+	r.Use(d.MiddlewareRequestID)
 
 	r.GET("/health", d.HealthcheckAPI.HealthcheckHandlerHTTP)
+	r.GET("/health/live", d.HealthcheckAPI.LivenessHandlerHTTP)
+	r.GET("/health/ready", d.HealthcheckAPI.ReadinessHandlerHTTP)
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+	if err := registerCodecServer(r, c.Config, c.Logger, d.MiddlewareValidateToken); err != nil {
+		return nil, err
+	}
 
 	transactionV1 := r.Group("/transaction/v1")
 	transactionV1.POST("/create", d.MiddlewareValidateToken, d.TransactionAPI.CreateTransaction)
 	transactionV1.PUT("/update-status/:reference", d.MiddlewareValidateToken, d.TransactionAPI.UpdateStatusTransaction)
+	transactionV1.GET("/:reference/state", d.MiddlewareValidateToken, d.TransactionAPI.GetTransactionState)
 	//transactionV1.GET("/", d.MiddlewareValidateToken, d.TransactionAPI.GetTransaction)
 	//transactionV1.GET("/:reference", d.MiddlewareValidateToken, d.TransactionAPI.GetTransactionDetail)
 	//transactionV1.POST("/refund", d.MiddlewareValidateToken, d.TransactionAPI.RefundTransaction)
 
-	err := r.Run(":" + helpers.GetEnv("APP_PORT", ""))
-	if err != nil {
-		log.Fatal(err)
-	}
+	walletV1 := r.Group("/wallet/v1")
+	walletV1.GET("/balance", d.MiddlewareValidateToken, d.WalletAPI.GetBalance)
+	return r, nil
 }
 
 type Dependency struct {
+	Logger         *slog.Logger
 	HealthcheckAPI interfaces.IHealthcheckAPI
-	External       interfaces.IExternal
+	TokenVerifier  interfaces.ITokenVerifier
 	TransactionAPI interfaces.ITransactionAPI
+	WalletAPI      interfaces.IWalletAPI
 }
 
-func dependencyInject(temporal client.Client) Dependency {
-	healthcheckSvc := &services.Healthcheck{}
-	healthcheckAPI := &api.Healthcheck{
-		HealthcheckServices: healthcheckSvc,
-	}
-
-	notifClient, err := external.NewNotificationClient(helpers.GetEnv("NOTIFICATION_HOST", ""))
+func dependencyInject(c *Container) (Dependency, error) {
+	healthcheckSvc, err := c.healthcheck()
 	if err != nil {
-		log.Fatal("failed to init notification client")
+		return Dependency{}, err
 	}
-
-	ext := &external.External{
-		NotificationClient: notifClient,
+	trxService, err := c.transactionService()
+	if err != nil {
+		return Dependency{}, err
 	}
-
-	trxRepo := &repository.TransactionRepo{
-		DB: helpers.DB,
+	ext, err := c.external()
+	if err != nil {
+		return Dependency{}, err
 	}
-	trxService := &services.TransactionService{
-		TransactionRepo: trxRepo,
-		External:        ext,
+	tc, err := c.temporal()
+	if err != nil {
+		return Dependency{}, err
 	}
-	trxAPI := &api.TransactionAPI{
-		TransactionService: trxService,
-		Temporal:           temporal,
+	verifier, err := c.tokenVerifier()
+	if err != nil {
+		return Dependency{}, err
 	}
 
 	return Dependency{
-		HealthcheckAPI: healthcheckAPI,
-		External:       ext,
-		TransactionAPI: trxAPI,
-	}
+		Logger: c.Logger,
+		HealthcheckAPI: &api.Healthcheck{
+			HealthcheckServices: healthcheckSvc,
+		},
+		TokenVerifier: verifier,
+		TransactionAPI: &api.TransactionAPI{
+			TransactionService: trxService,
+			Temporal:           tc,
+			TaskQueues:         c.Config.Temporal.TaskQueues,
+			Logger:             c.Logger,
+		},
+		WalletAPI: &api.WalletAPI{
+			WalletService: &services.WalletService{
+				External: ext,
+			},
+			Logger: c.Logger,
+		},
+	}, nil
+}
+
+func (d *Dependency) recoverHandler(c *gin.Context, err any) {
+	d.Logger.ErrorContext(c.Request.Context(), "panic recovered", "error", err, "stack", string(debug.Stack()))
+	c.AbortWithStatus(http.StatusInternalServerError)
 }
@@ -1,28 +1,64 @@
 package cmd
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"ewallet-topup/helpers"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+const RequestIDHeader = "X-Request-Id"
+
+// MiddlewareRequestID reuses the caller's X-Request-Id or generates one, puts it on every
+// log line of the request and writes one access log entry when the request is done.
+func (d *Dependency) MiddlewareRequestID(c *gin.Context) {
+	requestID := c.GetHeader(RequestIDHeader)
+	if requestID == "" || len(requestID) > 64 {
+		requestID = newRequestID()
+	}
+	c.Header(RequestIDHeader, requestID)
+
+	ctx := helpers.WithLogFields(c.Request.Context(), "request_id", requestID)
+	c.Request = c.Request.WithContext(ctx)
+
+	start := time.Now()
+	c.Next()
+
+	level := helpers.LevelForStatus(c.Writer.Status())
+	d.Logger.Log(c.Request.Context(), level, "http request",
+		"method", c.Request.Method,
+		"route", c.FullPath(),
+		"status", c.Writer.Status(),
+		"latency_ms", time.Since(start).Milliseconds(),
+	)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 func (d *Dependency) MiddlewareValidateToken(c *gin.Context) {
 
 	var (
-		log = helpers.Logger
+		log = d.Logger
+		ctx = c.Request.Context()
 	)
 	auth := c.GetHeader("Authorization")
 	if auth == "" {
-		log.Warn("Authorization header is empty")
+		log.WarnContext(ctx, "Authorization header is empty")
 		helpers.SendResponseHTTP(c, http.StatusUnauthorized, "unauthorized", nil)
 		c.Abort()
 		return
 	}
 	const prefix = "Bearer "
 	if !strings.HasPrefix(auth, prefix) {
-		log.Warn("invalid authorization header format")
+		log.WarnContext(ctx, "invalid authorization header format")
 		helpers.SendResponseHTTP(c, http.StatusUnauthorized, "unauthorized", nil)
 		c.Abort()
 		return
@@ -30,9 +66,9 @@ func (d *Dependency) MiddlewareValidateToken(c *gin.Context) {
 
 	token := strings.TrimPrefix(auth, prefix)
 
-	tokenData, err := d.External.ValidateToken(c.Request.Context(), token)
+	tokenData, err := d.TokenVerifier.ValidateToken(ctx, token)
 	if err != nil {
-		log.Error(err)
+		log.ErrorContext(ctx, "failed to validate token", "error", err)
 		helpers.SendResponseHTTP(c, http.StatusUnauthorized, "unauthorized", nil)
 		c.Abort()
 		return
@@ -41,6 +77,7 @@ func (d *Dependency) MiddlewareValidateToken(c *gin.Context) {
 	tokenData.Token = auth
 
 	c.Set("token", tokenData)
+	c.Request = c.Request.WithContext(helpers.WithLogFields(ctx, "user_id", tokenData.UserID))
 
 	c.Next()
 }
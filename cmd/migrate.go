@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"ewallet-topup/internal/migrations"
+	"flag"
+	"fmt"
+)
+
+func migrateFlags(fs *flag.FlagSet) func(c *Container, args []string) error {
+	steps := fs.Int("steps", 1, "number of migrations to revert with down")
+	return func(c *Container, args []string) error {
+		if len(args) != 1 {
+			return usageError("migrate needs exactly one of up, down, status")
+		}
+		if err := oneOf(args[0], "up", "down", "status"); err != nil {
+			return err
+		}
+		if *steps < 1 {
+			return usageError("-steps must be at least 1")
+		}
+
+		defer c.App.Shutdown()
+
+		// not c.db, that refuses to start on the outdated schema this command is here to fix.
+		// migrations only ever touch the primary.
+		dbCfg := c.Config.DB
+		dbCfg.ReplicaHosts = nil
+		db, err := c.connectDB(dbCfg)
+		if err != nil {
+			return err
+		}
+		migrator, err := c.migrator(db)
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+		switch args[0] {
+		case "up":
+			applied, err := migrator.Up(ctx)
+			if err != nil {
+				return err
+			}
+			c.Logger.Info("database schema is up to date", "applied", applied)
+			return nil
+		case "down":
+			reverted, err := migrator.Down(ctx, *steps)
+			if err != nil {
+				return err
+			}
+			c.Logger.Info("migrations reverted", "reverted", reverted)
+			return nil
+		default:
+			return migrateStatus(ctx, migrator)
+		}
+	}
+}
+
+// migrateStatus prints one line per migration, findings are reported when any is pending or dirty.
+func migrateStatus(ctx context.Context, migrator *migrations.Migrator) error {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	pending := false
+	for _, status := range statuses {
+		state := "pending"
+		switch {
+		case status.Dirty:
+			state = "dirty"
+		case status.Applied:
+			state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		if !status.Applied || status.Dirty {
+			pending = true
+		}
+		fmt.Printf("%04d  %-32s %s\n", status.Version, status.Name, state)
+	}
+	if pending {
+		return errFindings
+	}
+	return nil
+}
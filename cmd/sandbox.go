@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+	"ewallet-topup/helpers"
+	"ewallet-topup/internal/config"
+	"ewallet-topup/internal/fakes"
+	"ewallet-topup/internal/models"
+	"flag"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sandboxFlags runs the api and the worker in one process against in-process fakes of the
+// wallet, ums and notification and an in-memory sqlite database, so the whole topup flow
+// works with only a temporal dev server. Nothing is kept after the process stops.
+func sandboxFlags(*flag.FlagSet) func(c *Container, args []string) error {
+	return func(c *Container, _ []string) error {
+		if c.Config.App.Profile == config.ProfileProd {
+			return usageError("sandbox fakes the wallet and must not run with the prod profile")
+		}
+		users, err := c.Config.Sandbox.ParseUsers()
+		if err != nil {
+			return err
+		}
+
+		ext := fakes.NewExternal()
+		for _, u := range users {
+			ext.AddUser(u.Token, models.TokenData{
+				UserID:   u.UserID,
+				Username: fmt.Sprintf("sandbox%d", u.UserID),
+				FullName: fmt.Sprintf("Sandbox User %d", u.UserID),
+				Email:    fmt.Sprintf("sandbox%d@example.com", u.UserID),
+			}, u.Balance)
+			c.Logger.Info("sandbox user ready, see SANDBOX_USERS for its token", "user_id", u.UserID, "balance", u.Balance)
+		}
+		c.External = ext
+		c.TokenVerifier = ext
+
+		db, pools, err := fakes.OpenSQLite(c.Logger)
+		if err != nil {
+			return err
+		}
+		c.App.Close("database", func(context.Context) error { return pools.Close() })
+		c.DB, c.DBPools = db, pools
+
+		if err := c.setupTracing(); err != nil {
+			return err
+		}
+		if _, err := c.temporal(); err != nil {
+			return err
+		}
+		if err := startWorker(c); err != nil {
+			return err
+		}
+		r, err := newRouter(c)
+		if err != nil {
+			return err
+		}
+		registerSandboxRoutes(r, ext)
+		serveRouter(c, r)
+		return c.App.Run(context.Background())
+	}
+}
+
+func registerSandboxRoutes(r *gin.Engine, ext *fakes.External) {
+	r.GET("/sandbox/notifications", func(c *gin.Context) {
+		sent := ext.Notifications()
+		notifications := make([]gin.H, 0, len(sent))
+		for _, n := range sent {
+			notifications = append(notifications, gin.H{
+				"user_id":       n.UserID,
+				"email":         n.Email,
+				"full_name":     n.FullName,
+				"reference":     n.Reference,
+				"type":          n.Type,
+				"amount":        n.Amount,
+				"balance_after": n.BalanceAfter,
+			})
+		}
+		helpers.SendResponseHTTP(c, http.StatusOK, "success", notifications)
+	})
+}
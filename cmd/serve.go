@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"ewallet-topup/internal/lifecycle"
+	"ewallet-topup/internal/metrics"
+	"ewallet-topup/internal/workflow"
+	"ewallet-topup/internal/workflow/transaction"
+	"flag"
+
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/worker"
+)
+
+func serveAPIFlags(fs *flag.FlagSet) func(c *Container, args []string) error {
+	withGRPC := fs.Bool("with-grpc", false, "also serve the grpc api from this process")
+	return func(c *Container, _ []string) error {
+		// everything registered on app is closed in reverse order once the servers drained
+		if err := c.setupTracing(); err != nil {
+			return err
+		}
+		if _, err := c.db(); err != nil {
+			return err
+		}
+		if _, err := c.temporal(); err != nil {
+			return err
+		}
+
+		if *withGRPC {
+			if err := ServeGRPC(c); err != nil {
+				return err
+			}
+		}
+		if err := ServeHTTP(c); err != nil {
+			return err
+		}
+		return c.App.Run(context.Background())
+	}
+}
+
+func serveGRPCFlags(*flag.FlagSet) func(c *Container, args []string) error {
+	return func(c *Container, _ []string) error {
+		if err := c.setupTracing(); err != nil {
+			return err
+		}
+		if err := ServeGRPC(c); err != nil {
+			return err
+		}
+		return c.App.Run(context.Background())
+	}
+}
+
+func workerFlags(*flag.FlagSet) func(c *Container, args []string) error {
+	return func(c *Container, _ []string) error {
+		// the worker takes no load balancer traffic, so it stops polling right away without a drain delay
+		if err := c.setupTracing(); err != nil {
+			return err
+		}
+		if err := startWorker(c); err != nil {
+			return err
+		}
+		c.App.Go(lifecycle.HTTPServer("metrics", metrics.NewServer(c.Config.App.WorkerMetricsPort)))
+		return c.App.Run(context.Background())
+	}
+}
+
+// startWorker registers the temporal worker with the container app.
+func startWorker(c *Container) error {
+	tc, err := c.temporal()
+	if err != nil {
+		return err
+	}
+	trxSvc, err := c.transactionService()
+	if err != nil {
+		return err
+	}
+	ext, err := c.external()
+	if err != nil {
+		return err
+	}
+
+	// the sticky cache is per process and must be sized before the first worker starts
+	worker.SetStickyWorkflowCacheSize(c.Config.Temporal.StickyCacheSize)
+	activities := &transaction.TransactionActivities{
+		Service:  trxSvc,
+		External: ext,
+	}
+	// one worker per task queue, so every transaction type gets its own pollers and slots
+	for _, queue := range c.Config.Temporal.TaskQueues.All() {
+		w := worker.New(
+			tc,
+			queue.Name,
+			worker.Options{
+				MaxConcurrentActivityExecutionSize:     queue.Worker.MaxConcurrentActivities,
+				MaxConcurrentWorkflowTaskExecutionSize: queue.Worker.MaxConcurrentWorkflowTasks,
+				MaxConcurrentActivityTaskPollers:       queue.Worker.ActivityPollers,
+				MaxConcurrentWorkflowTaskPollers:       queue.Worker.WorkflowTaskPollers,
+				TaskQueueActivitiesPerSecond:           queue.Worker.ActivitiesPerSecond,
+				Interceptors:                           []interceptor.WorkerInterceptor{workflow.NewLoggingInterceptor()},
+				// running activities get this long to finish before their context is cancelled
+				WorkerStopTimeout: c.Config.Shutdown.ShutdownTimeout,
+			},
+		)
+		w.RegisterWorkflow(transaction.TransactionWorkflow)
+		w.RegisterActivity(activities)
+
+		stopWorker := make(chan interface{})
+		name := queue.Name
+		c.App.Go(lifecycle.Service{
+			Name: "temporal_worker_" + name,
+			Run: func() error {
+				c.Logger.Info("temporal worker started", "task_queue", name)
+				return w.Run(stopWorker)
+			},
+			// w.Run stops polling, waits for running tasks up to WorkerStopTimeout and then returns
+			Stop: func(context.Context) error { close(stopWorker); return nil },
+		})
+	}
+	return nil
+}
@@ -6,6 +6,15 @@ const (
 	SuccessMessage      = "success"
 	ErrFailedBadRequest = "data tidak sesuai"
 	ErrServerError      = "terjadi kesalahan pada server"
+	ErrUnauthorized     = "unauthorized"
+	ErrInsufficientBal  = "saldo tidak mencukupi"
+	ErrNotFound         = "data tidak ditemukan"
+	ErrNotPending       = "transaksi sudah tidak menunggu konfirmasi"
+)
+
+const (
+	ErrCodeInsufficientBalance = "INSUFFICIENT_BALANCE"
+	ErrCodeNotPending          = "TRANSACTION_NOT_PENDING"
 )
 
 const (
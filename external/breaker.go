@@ -0,0 +1,243 @@
+package external
+
+import (
+	"context"
+	"ewallet-topup/helpers"
+	"ewallet-topup/internal/metrics"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+var (
+	ErrCircuitOpen  = errors.New("circuit breaker is open")
+	ErrBulkheadFull = errors.New("bulkhead is full")
+)
+
+// CircuitOpenError is returned without calling the dependency while the breaker is open.
+type CircuitOpenError struct {
+	Name       string
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s: %s, retry after %s", e.Name, ErrCircuitOpen, e.RetryAfter)
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+type BreakerConfig struct {
+	FailureThreshold int
+	OpenTimeout      time.Duration
+	HalfOpenMaxCalls int
+	MaxConcurrent    int
+	BulkheadWait     time.Duration
+}
+
+// LoadBreakerConfig reads <prefix>_BREAKER_* and <prefix>_BULKHEAD_*, e.g. WALLET_BREAKER_FAILURE_THRESHOLD.
+func LoadBreakerConfig(env *helpers.Env, prefix string) BreakerConfig {
+	return BreakerConfig{
+		FailureThreshold: env.GetInt(prefix+"_BREAKER_FAILURE_THRESHOLD", 5),
+		OpenTimeout:      env.GetDuration(prefix+"_BREAKER_OPEN_TIMEOUT", 30*time.Second),
+		HalfOpenMaxCalls: env.GetInt(prefix+"_BREAKER_HALF_OPEN_MAX_CALLS", 1),
+		MaxConcurrent:    env.GetInt(prefix+"_BULKHEAD_MAX_CONCURRENT", 20),
+		BulkheadWait:     env.GetDuration(prefix+"_BULKHEAD_WAIT", 100*time.Millisecond),
+	}
+}
+
+type BreakerSnapshot struct {
+	Name     string       `json:"name"`
+	State    BreakerState `json:"state"`
+	Failures int          `json:"failures"`
+	InFlight int          `json:"in_flight"`
+}
+
+// CircuitBreaker combines a consecutive failure breaker with a concurrency bulkhead,
+// so a slow dependency can neither be hammered nor hold every worker slot.
+type CircuitBreaker struct {
+	Name      string
+	Config    BreakerConfig
+	IsFailure func(error) bool
+	Now       func() time.Time
+
+	mu               sync.Mutex
+	state            BreakerState
+	failures         int
+	openedAt         time.Time
+	halfOpenInFlight int
+	halfOpenSuccess  int
+	slots            chan struct{}
+}
+
+func NewCircuitBreaker(name string, cfg BreakerConfig, isFailure func(error) bool) *CircuitBreaker {
+	if isFailure == nil {
+		isFailure = func(err error) bool { return err != nil }
+	}
+	b := &CircuitBreaker{
+		Name:      name,
+		Config:    cfg,
+		IsFailure: isFailure,
+		Now:       time.Now,
+	}
+	b.setState(BreakerClosed)
+	if cfg.MaxConcurrent > 0 {
+		b.slots = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	return b
+}
+
+// Execute runs fn through the bulkhead and breaker and records the call in the external metrics.
+func (b *CircuitBreaker) Execute(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	if b == nil {
+		return fn(ctx)
+	}
+
+	if err := b.acquire(ctx); err != nil {
+		metrics.ExternalRequests.WithLabelValues(b.Name, operation, "bulkhead_full").Inc()
+		return err
+	}
+	defer b.release()
+
+	halfOpen, err := b.before()
+	if err != nil {
+		metrics.ExternalRequests.WithLabelValues(b.Name, operation, "circuit_open").Inc()
+		return err
+	}
+
+	start := time.Now()
+	err = fn(ctx)
+	metrics.ExternalRequestDuration.WithLabelValues(b.Name, operation).Observe(time.Since(start).Seconds())
+
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	metrics.ExternalRequests.WithLabelValues(b.Name, operation, outcome).Inc()
+
+	b.after(halfOpen, b.IsFailure(err))
+	return err
+}
+
+func (b *CircuitBreaker) acquire(ctx context.Context) error {
+	if b.slots == nil {
+		return nil
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(b.Config.BulkheadWait)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errors.Wrap(ErrBulkheadFull, b.Name)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *CircuitBreaker) release() {
+	if b.slots != nil {
+		<-b.slots
+	}
+}
+
+func (b *CircuitBreaker) before() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		remaining := b.Config.OpenTimeout - b.Now().Sub(b.openedAt)
+		if remaining > 0 {
+			return false, &CircuitOpenError{Name: b.Name, RetryAfter: remaining}
+		}
+		b.setState(BreakerHalfOpen)
+		b.halfOpenInFlight = 0
+		b.halfOpenSuccess = 0
+	}
+
+	if b.state == BreakerHalfOpen {
+		if b.halfOpenInFlight >= max(b.Config.HalfOpenMaxCalls, 1) {
+			return false, &CircuitOpenError{Name: b.Name, RetryAfter: b.Config.OpenTimeout}
+		}
+		b.halfOpenInFlight++
+		return true, nil
+	}
+	return false, nil
+}
+
+func (b *CircuitBreaker) after(halfOpen bool, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if halfOpen {
+		b.halfOpenInFlight--
+	}
+
+	if failed {
+		b.failures++
+		if b.state == BreakerHalfOpen || b.failures >= b.Config.FailureThreshold {
+			b.setState(BreakerOpen)
+			b.openedAt = b.Now()
+		}
+		return
+	}
+
+	if b.state == BreakerHalfOpen {
+		b.halfOpenSuccess++
+		if b.halfOpenSuccess < max(b.Config.HalfOpenMaxCalls, 1) {
+			return
+		}
+		b.setState(BreakerClosed)
+	}
+	b.failures = 0
+}
+
+// setState must be called with mu held.
+func (b *CircuitBreaker) setState(state BreakerState) {
+	b.state = state
+	metrics.CircuitBreakerState.WithLabelValues(b.Name).Set(breakerStateValue[state])
+}
+
+var breakerStateValue = map[BreakerState]float64{
+	BreakerClosed:   0,
+	BreakerHalfOpen: 1,
+	BreakerOpen:     2,
+}
+
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && b.Now().Sub(b.openedAt) >= b.Config.OpenTimeout {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+func (b *CircuitBreaker) Snapshot() BreakerSnapshot {
+	state := b.State()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerSnapshot{
+		Name:     b.Name,
+		State:    state,
+		Failures: b.failures,
+		InFlight: len(b.slots),
+	}
+}
@@ -0,0 +1,441 @@
+package external
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"ewallet-topup/helpers"
+	"ewallet-topup/internal/models"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+type JWTConfig struct {
+	JWKSURL         string
+	JWKSFile        string
+	RefreshInterval time.Duration
+	Issuer          string
+	Audience        string
+	Leeway          time.Duration
+}
+
+func LoadJWTConfig(env *helpers.Env) JWTConfig {
+	return JWTConfig{
+		JWKSURL:         env.Get("JWT_JWKS_URL", ""),
+		JWKSFile:        env.Get("JWT_JWKS_FILE", ""),
+		RefreshInterval: env.GetDuration("JWT_JWKS_REFRESH_INTERVAL", 5*time.Minute),
+		Issuer:          env.Get("JWT_ISSUER", ""),
+		Audience:        env.Get("JWT_AUDIENCE", ""),
+		Leeway:          env.GetDuration("JWT_LEEWAY", 30*time.Second),
+	}
+}
+
+// JWTVerifier validates RS256/ES256 tokens locally against a JWKS document,
+// so requests can still be authenticated while UMS is down.
+type JWTVerifier struct {
+	Config     JWTConfig
+	HTTPClient *http.Client
+	Now        func() time.Time
+	Logger     *slog.Logger
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	lastRefresh time.Time
+	// lastAttempt is the last refresh started for an unknown kid, successful or not
+	lastAttempt time.Time
+	group       singleflight.Group
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+func NewJWTVerifier(cfg JWTConfig, logger *slog.Logger) (*JWTVerifier, error) {
+	if cfg.JWKSURL == "" && cfg.JWKSFile == "" {
+		return nil, errors.New("JWT_JWKS_URL or JWT_JWKS_FILE is required for jwt auth mode")
+	}
+	if cfg.Audience == "" {
+		return nil, errors.New("JWT_AUDIENCE is required for jwt auth mode")
+	}
+
+	v := &JWTVerifier{
+		Config:     cfg,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		Now:        time.Now,
+		Logger:     logger,
+		keys:       map[string]crypto.PublicKey{},
+		stop:       make(chan struct{}),
+	}
+	if err := v.Refresh(context.Background()); err != nil {
+		return nil, err
+	}
+
+	if cfg.RefreshInterval > 0 {
+		go v.refreshLoop()
+	}
+	return v, nil
+}
+
+func (v *JWTVerifier) Close() {
+	v.stopOnce.Do(func() { close(v.stop) })
+}
+
+func (v *JWTVerifier) refreshLoop() {
+	ticker := time.NewTicker(v.Config.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-v.stop:
+			return
+		case <-ticker.C:
+			if err := v.Refresh(context.Background()); err != nil {
+				// keep serving with the previous key set
+				v.Logger.Warn("failed to refresh jwks", "error", err)
+			}
+		}
+	}
+}
+
+// Refresh reloads the key set from file or URL and swaps it in atomically.
+func (v *JWTVerifier) Refresh(ctx context.Context) error {
+	raw, err := v.loadJWKS(ctx)
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(raw, v.Logger)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.lastRefresh = v.Now()
+	v.mu.Unlock()
+	return nil
+}
+
+func (v *JWTVerifier) loadJWKS(ctx context.Context) ([]byte, error) {
+	if v.Config.JWKSFile != "" {
+		raw, err := os.ReadFile(v.Config.JWKSFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read jwks file")
+		}
+		return raw, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.Config.JWKSURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create jwks request")
+	}
+	resp, err := v.HTTPClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch jwks")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got error response from jwks endpoint: %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+func (v *JWTVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, bool) {
+	v.mu.RLock()
+	key, ok := v.keys[kid]
+	v.mu.RUnlock()
+	if ok {
+		return key, true
+	}
+
+	// unknown kid usually means the issuer rotated keys. Concurrent lookups share one refresh,
+	// which runs at most once a minute even when it fails
+	_, _, _ = v.group.Do("refresh", func() (interface{}, error) {
+		v.mu.Lock()
+		now := v.Now()
+		if now.Sub(v.lastRefresh) < time.Minute || now.Sub(v.lastAttempt) < time.Minute {
+			v.mu.Unlock()
+			return nil, nil
+		}
+		v.lastAttempt = now
+		v.mu.Unlock()
+
+		// the refresh is shared, one caller going away must not cancel it for the others
+		if err := v.Refresh(context.WithoutCancel(ctx)); err != nil {
+			v.Logger.Warn("failed to refresh jwks", "error", err)
+		}
+		return nil, nil
+	})
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	key, ok = v.keys[kid]
+	return key, ok
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer            string          `json:"iss"`
+	Subject           string          `json:"sub"`
+	Audience          json.RawMessage `json:"aud"`
+	ExpiresAt         *int64          `json:"exp"`
+	NotBefore         *int64          `json:"nbf"`
+	UserID            json.RawMessage `json:"user_id"`
+	Username          string          `json:"username"`
+	PreferredUsername string          `json:"preferred_username"`
+	FullName          string          `json:"full_name"`
+	Name              string          `json:"name"`
+	Email             string          `json:"email"`
+}
+
+func (v *JWTVerifier) ValidateToken(ctx context.Context, token string) (models.TokenData, error) {
+	var (
+		resp models.TokenData
+	)
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return resp, errors.New("malformed jwt")
+	}
+
+	header := jwtHeader{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return resp, errors.Wrap(err, "invalid jwt header")
+	}
+	key, ok := v.key(ctx, header.Kid)
+	if !ok {
+		return resp, fmt.Errorf("unknown jwt key id %q", header.Kid)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return resp, errors.Wrap(err, "invalid jwt signature encoding")
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return resp, err
+	}
+
+	claims := jwtClaims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return resp, errors.Wrap(err, "invalid jwt claims")
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return resp, err
+	}
+
+	return claimsToTokenData(claims)
+}
+
+func (v *JWTVerifier) validateClaims(claims jwtClaims) error {
+	now := v.Now()
+	if claims.ExpiresAt == nil {
+		return errors.New("jwt has no exp claim")
+	}
+	if now.After(time.Unix(*claims.ExpiresAt, 0).Add(v.Config.Leeway)) {
+		return errors.New("jwt is expired")
+	}
+	if claims.NotBefore != nil && now.Add(v.Config.Leeway).Before(time.Unix(*claims.NotBefore, 0)) {
+		return errors.New("jwt is not valid yet")
+	}
+	if v.Config.Issuer != "" && claims.Issuer != v.Config.Issuer {
+		return fmt.Errorf("unexpected jwt issuer %q", claims.Issuer)
+	}
+	if !hasAudience(claims.Audience, v.Config.Audience) {
+		return errors.New("jwt audience mismatch")
+	}
+	return nil
+}
+
+func claimsToTokenData(claims jwtClaims) (models.TokenData, error) {
+	var (
+		resp models.TokenData
+	)
+
+	rawUserID := strings.Trim(string(claims.UserID), `"`)
+	if rawUserID == "" || rawUserID == "null" {
+		rawUserID = claims.Subject
+	}
+	userID, err := strconv.ParseInt(rawUserID, 10, 64)
+	if err != nil {
+		return resp, fmt.Errorf("jwt has no numeric user id")
+	}
+
+	resp.UserID = userID
+	resp.Username = firstNonEmpty(claims.Username, claims.PreferredUsername)
+	resp.FullName = firstNonEmpty(claims.FullName, claims.Name)
+	resp.Email = claims.Email
+
+	return resp, nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signingInput, signature []byte) error {
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("jwt alg RS256 does not match key type")
+		}
+		digest := sha256.Sum256(signingInput)
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("invalid jwt signature")
+		}
+		return nil
+	case "ES256", "ES384":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("jwt alg %s does not match key type", alg)
+		}
+		var digest []byte
+		if alg == "ES256" {
+			sum := sha256.Sum256(signingInput)
+			digest = sum[:]
+		} else {
+			sum := sha512.Sum384(signingInput)
+			digest = sum[:]
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid jwt signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("invalid jwt signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported jwt alg %q", alg)
+	}
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS returns the signing keys of the set by kid. Keys it cannot use, e.g. an OKP key or
+// a P-521 curve next to the RSA keys, are logged and skipped so they do not take the others down.
+func parseJWKS(raw []byte, logger *slog.Logger) (map[string]crypto.PublicKey, error) {
+	doc := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, errors.Wrap(err, "failed to parse jwks")
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			logger.Warn("skipping unusable jwk", "kid", k.Kid, "kty", k.Kty, "crv", k.Crv, "error", err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks has no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("ec point is not on curve")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeSegment(segment string, out interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, out)
+}
+
+func hasAudience(raw json.RawMessage, audience string) bool {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return single == audience
+	}
+	var many []string
+	if err := json.Unmarshal(raw, &many); err == nil {
+		for _, aud := range many {
+			if aud == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
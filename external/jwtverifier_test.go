@@ -0,0 +1,348 @@
+package external
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://ums.test"
+	testAudience = "ewallet-topup"
+)
+
+type testSigner struct {
+	kid string
+	key crypto.Signer
+}
+
+func newRSASigner(t *testing.T, kid string) testSigner {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testSigner{kid: kid, key: key}
+}
+
+func newECSigner(t *testing.T, kid string) testSigner {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testSigner{kid: kid, key: key}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (s testSigner) jwk() jwk {
+	switch key := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		return jwk{Kty: "RSA", Kid: s.kid, Use: "sig", N: b64(key.N.Bytes()), E: b64(big.NewInt(int64(key.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return jwk{Kty: "EC", Kid: s.kid, Use: "sig", Crv: "P-256", X: b64(key.X.FillBytes(make([]byte, 32))), Y: b64(key.Y.FillBytes(make([]byte, 32)))}
+	}
+	panic("unsupported key")
+}
+
+func (s testSigner) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	alg := "RS256"
+	if _, ok := s.key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(jwtHeader{Alg: alg, Kid: s.kid})
+	payload, _ := json.Marshal(claims)
+	input := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, sv, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), sv.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + b64(sig)
+}
+
+// jwksServer serves the current key set and counts the fetches.
+type jwksServer struct {
+	mu      sync.Mutex
+	keys    []jwk
+	status  int
+	fetches atomic.Int32
+	url     string
+}
+
+func newJWKSServer(t *testing.T, signers ...testSigner) *jwksServer {
+	t.Helper()
+	s := &jwksServer{status: http.StatusOK}
+	s.setKeys(signers...)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.status != http.StatusOK {
+			w.WriteHeader(s.status)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+	}))
+	t.Cleanup(srv.Close)
+	s.url = srv.URL
+	return s
+}
+
+func (s *jwksServer) setKeys(signers ...testSigner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = nil
+	for _, signer := range signers {
+		s.keys = append(s.keys, signer.jwk())
+	}
+}
+
+func (s *jwksServer) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+// testClock is a settable Now for the verifier.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestVerifier(t *testing.T, jwks *jwksServer) (*JWTVerifier, *testClock) {
+	t.Helper()
+	v, err := NewJWTVerifier(JWTConfig{
+		JWKSURL:  jwks.url,
+		Issuer:   testIssuer,
+		Audience: testAudience,
+		Leeway:   30 * time.Second,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewJWTVerifier: %v", err)
+	}
+	t.Cleanup(v.Close)
+	clock := &testClock{now: time.Now()}
+	v.Now = clock.Now
+	return v, clock
+}
+
+func validClaims(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":       testIssuer,
+		"aud":       []string{"other", testAudience},
+		"sub":       "42",
+		"exp":       now.Add(time.Hour).Unix(),
+		"username":  "budi",
+		"full_name": "Budi Santoso",
+		"email":     "budi@example.com",
+	}
+}
+
+func TestJWTVerifierValidToken(t *testing.T) {
+	for _, signer := range []testSigner{newRSASigner(t, "rsa-1"), newECSigner(t, "ec-1")} {
+		t.Run(signer.kid, func(t *testing.T) {
+			v, clock := newTestVerifier(t, newJWKSServer(t, signer))
+
+			got, err := v.ValidateToken(context.Background(), signer.sign(t, validClaims(clock.Now())))
+			if err != nil {
+				t.Fatalf("ValidateToken: %v", err)
+			}
+			if got.UserID != 42 || got.Username != "budi" || got.FullName != "Budi Santoso" || got.Email != "budi@example.com" {
+				t.Errorf("token data = %+v", got)
+			}
+		})
+	}
+}
+
+func TestJWTVerifierRejects(t *testing.T) {
+	signer := newRSASigner(t, "rsa-1")
+	other := newRSASigner(t, "rsa-1")
+	v, clock := newTestVerifier(t, newJWKSServer(t, signer))
+	now := clock.Now()
+
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := validClaims(now)
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"other audience", signer.sign(t, with("aud", "payments")), "audience"},
+		{"no audience", signer.sign(t, with("aud", nil)), "audience"},
+		{"other issuer", signer.sign(t, with("iss", "https://evil.test")), "issuer"},
+		{"expired", signer.sign(t, with("exp", now.Add(-time.Minute).Unix())), "expired"},
+		{"no exp", signer.sign(t, with("exp", nil)), "exp"},
+		{"not yet valid", signer.sign(t, with("nbf", now.Add(time.Minute).Unix())), "not valid yet"},
+		{"signed by another key", other.sign(t, validClaims(now)), "signature"},
+		{"malformed", "not-a-jwt", "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.ValidateToken(context.Background(), tt.token)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("ValidateToken error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewJWTVerifierRequiresAudience(t *testing.T) {
+	jwks := newJWKSServer(t, newRSASigner(t, "rsa-1"))
+	_, err := NewJWTVerifier(JWTConfig{JWKSURL: jwks.url}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err == nil || !strings.Contains(err.Error(), "JWT_AUDIENCE") {
+		t.Fatalf("NewJWTVerifier error = %v, want JWT_AUDIENCE required", err)
+	}
+}
+
+func TestJWTVerifierPicksUpRotatedKey(t *testing.T) {
+	old := newRSASigner(t, "rsa-1")
+	rotated := newECSigner(t, "ec-2")
+	jwks := newJWKSServer(t, old)
+	v, clock := newTestVerifier(t, jwks)
+	jwks.setKeys(old, rotated)
+
+	// a refresh just ran at startup, the new kid is not looked up yet
+	if _, err := v.ValidateToken(context.Background(), rotated.sign(t, validClaims(clock.Now()))); err == nil {
+		t.Fatal("token with an unknown kid accepted right after a refresh")
+	}
+
+	clock.Add(2 * time.Minute)
+	if _, err := v.ValidateToken(context.Background(), rotated.sign(t, validClaims(clock.Now()))); err != nil {
+		t.Fatalf("ValidateToken after rotation: %v", err)
+	}
+	if got := jwks.fetches.Load(); got != 2 {
+		t.Errorf("jwks fetched %d times, want 2", got)
+	}
+}
+
+func TestJWTVerifierUnknownKidRefreshesOnce(t *testing.T) {
+	jwks := newJWKSServer(t, newRSASigner(t, "rsa-1"))
+	v, clock := newTestVerifier(t, jwks)
+	clock.Add(2 * time.Minute)
+	unknown := newRSASigner(t, "unknown")
+	token := unknown.sign(t, validClaims(clock.Now()))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = v.ValidateToken(context.Background(), token)
+		}()
+	}
+	wg.Wait()
+
+	if got := jwks.fetches.Load(); got != 2 {
+		t.Errorf("jwks fetched %d times, want 2, once at startup and once for the unknown kid", got)
+	}
+}
+
+func TestJWTVerifierFailedRefreshIsRateLimited(t *testing.T) {
+	signer := newRSASigner(t, "rsa-1")
+	jwks := newJWKSServer(t, signer)
+	v, clock := newTestVerifier(t, jwks)
+	clock.Add(2 * time.Minute)
+	jwks.setStatus(http.StatusServiceUnavailable)
+	token := newRSASigner(t, "unknown").sign(t, validClaims(clock.Now()))
+
+	for i := 0; i < 3; i++ {
+		_, _ = v.ValidateToken(context.Background(), token)
+	}
+	if got := jwks.fetches.Load(); got != 2 {
+		t.Errorf("jwks fetched %d times, want 2", got)
+	}
+
+	// the previous key set keeps working
+	if _, err := v.ValidateToken(context.Background(), signer.sign(t, validClaims(clock.Now()))); err != nil {
+		t.Fatalf("ValidateToken with a known kid: %v", err)
+	}
+}
+
+func TestJWTVerifierSkipsUnusableKeys(t *testing.T) {
+	signer := newRSASigner(t, "rsa-1")
+	unusable := []jwk{
+		{Kty: "OKP", Kid: "ed-1", Use: "sig", Crv: "Ed25519", X: b64(make([]byte, 32))},
+		{Kty: "EC", Kid: "ec-521", Use: "sig", Crv: "P-521", X: b64([]byte{1}), Y: b64([]byte{2})},
+		{Kty: "EC", Kid: "ec-off-curve", Use: "sig", Crv: "P-256", X: b64([]byte{1}), Y: b64([]byte{2})},
+	}
+	jwks := newJWKSServer(t, signer)
+	jwks.mu.Lock()
+	jwks.keys = append(jwks.keys, unusable...)
+	jwks.mu.Unlock()
+
+	var logs strings.Builder
+	v, err := NewJWTVerifier(JWTConfig{JWKSURL: jwks.url, Issuer: testIssuer, Audience: testAudience},
+		slog.New(slog.NewTextHandler(&logs, nil)))
+	if err != nil {
+		t.Fatalf("NewJWTVerifier with a mixed key set: %v", err)
+	}
+	t.Cleanup(v.Close)
+	if _, err := v.ValidateToken(context.Background(), signer.sign(t, validClaims(time.Now()))); err != nil {
+		t.Errorf("ValidateToken with the usable key: %v", err)
+	}
+	for _, k := range unusable {
+		if !strings.Contains(logs.String(), "kid="+k.Kid) {
+			t.Errorf("skipped key %s was not logged:\n%s", k.Kid, logs.String())
+		}
+	}
+
+	jwks.mu.Lock()
+	jwks.keys = unusable
+	jwks.mu.Unlock()
+	if _, err := NewJWTVerifier(JWTConfig{JWKSURL: jwks.url, Issuer: testIssuer, Audience: testAudience},
+		slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+		t.Error("a key set without a usable key was accepted")
+	}
+}
@@ -2,24 +2,85 @@ package external
 
 import (
 	"context"
+	"errors"
 	notificationpb "ewallet-topup/external/proto/notification"
+	"ewallet-topup/helpers"
 	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
 
-	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 )
 
 type External struct {
 	NotificationClient *NotificationClient
+	Wallet             *WalletClient
+	UMS                *UMSClient
+}
+
+// Config groups the settings of every downstream dependency.
+type Config struct {
+	Notification NotificationConfig
+	UMS          UMSConfig
+	Wallet       WalletConfig
+	ServiceToken ServiceTokenConfig
+	JWT          JWTConfig
+}
+
+func LoadConfig(env *helpers.Env) Config {
+	return Config{
+		Notification: LoadNotificationConfig(env),
+		UMS:          LoadUMSConfig(env),
+		Wallet:       LoadWalletConfig(env),
+		ServiceToken: LoadServiceTokenConfig(env),
+		JWT:          LoadJWTConfig(env),
+	}
 }
 
 // Init client sekali di startup
-func NewExternal(grpcAddr string) (*External, error) {
-	client, err := NewNotificationClient(grpcAddr)
+func NewExternal(cfg Config, logger *slog.Logger) (*External, error) {
+	client, err := NewNotificationClient(cfg.Notification, logger)
+	if err != nil {
+		return nil, err
+	}
+	umsClient, err := NewUMSClient(cfg.UMS, logger)
 	if err != nil {
 		return nil, err
 	}
-	return &External{NotificationClient: client}, nil
+	return &External{
+		NotificationClient: client,
+		Wallet:             NewWalletClient(cfg.Wallet, NewServiceTokenSigner(cfg.ServiceToken)),
+		UMS:                umsClient,
+	}, nil
+}
+
+// BreakerStates lists the circuit breaker of every dependency for health and metrics.
+func (e *External) BreakerStates() []BreakerSnapshot {
+	states := []BreakerSnapshot{}
+	if e.Wallet != nil && e.Wallet.Breaker != nil {
+		states = append(states, e.Wallet.Breaker.Snapshot())
+	}
+	if e.UMS != nil && e.UMS.Breaker != nil {
+		states = append(states, e.UMS.Breaker.Snapshot())
+	}
+	if e.NotificationClient != nil && e.NotificationClient.Breaker != nil {
+		states = append(states, e.NotificationClient.Breaker.Snapshot())
+	}
+	return states
+}
+
+// Close closes the grpc connections to notification and ums.
+func (e *External) Close() error {
+	var errs []error
+	if e.NotificationClient != nil {
+		errs = append(errs, e.NotificationClient.Close())
+	}
+	if e.UMS != nil {
+		errs = append(errs, e.UMS.Close())
+	}
+	return errors.Join(errs...)
 }
 
 func (e *External) NotifyUserRegistered(userID int64, email, fullName string) error {
@@ -29,19 +90,54 @@ func (e *External) NotifyUserRegistered(userID int64, email, fullName string) er
 	return e.NotificationClient.SendNotification(userID, email, fullName)
 }
 
+func (e *External) NotifyTransaction(ctx context.Context, data TransactionNotification) error {
+	if e.NotificationClient == nil {
+		return fmt.Errorf("notification client not initialized")
+	}
+	return e.NotificationClient.SendTransactionNotification(ctx, data)
+}
+
 // --- NotificationClient ---
 type NotificationClient struct {
-	Conn   *grpc.ClientConn
-	Client notificationpb.NotificationServiceClient
+	Conn    *grpc.ClientConn
+	Client  notificationpb.NotificationServiceClient
+	Breaker *CircuitBreaker
+	Logger  *slog.Logger
 }
 
-func NewNotificationClient(addr string) (*NotificationClient, error) {
-	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+type NotificationConfig struct {
+	Host    string
+	TLS     helpers.TLSConfig
+	Breaker BreakerConfig
+}
+
+func LoadNotificationConfig(env *helpers.Env) NotificationConfig {
+	return NotificationConfig{
+		Host:    env.Get("NOTIFICATION_HOST", ""),
+		TLS:     helpers.LoadTLSConfig(env, "NOTIFICATION"),
+		Breaker: LoadBreakerConfig(env, "NOTIFICATION"),
+	}
+}
+
+func NewNotificationClient(cfg NotificationConfig, logger *slog.Logger) (*NotificationClient, error) {
+	creds, err := cfg.TLS.ClientCredentials(logger)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.NewClient(cfg.Host,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
 	if err != nil {
 		return nil, err
 	}
 	client := notificationpb.NewNotificationServiceClient(conn)
-	return &NotificationClient{Conn: conn, Client: client}, nil
+	return &NotificationClient{
+		Conn:    conn,
+		Client:  client,
+		Breaker: NewCircuitBreaker("notification", cfg.Breaker, isGRPCUnavailable),
+		Logger:  logger,
+	}, nil
 }
 
 func (n *NotificationClient) Close() error {
@@ -67,21 +163,82 @@ func (n *NotificationClient) SendNotification(userID int64, email, fullName stri
 		},
 	}
 
-	resp, err := n.Client.SendNotification(context.Background(), req)
+	resp, err := n.send(context.Background(), req)
 	if err != nil {
 		return fmt.Errorf("grpc send failed: %w", err)
 	}
 
-	switch resp.Status {
-	case "PENDING", "PROCESSING", "SUCCESS":
-		return nil
-	default:
-		return fmt.Errorf("notification rejected: %s", resp.Status)
+	return n.statusError(resp.Status)
+
+}
+
+type TransactionNotification struct {
+	UserID       int64
+	Email        string
+	FullName     string
+	Reference    string
+	Type         string
+	Amount       float64
+	BalanceAfter *float64
+}
+
+func (n *NotificationClient) SendTransactionNotification(ctx context.Context, data TransactionNotification) error {
+	body := fmt.Sprintf("Hi %s, your %s transaction %s of %.2f was successful.",
+		data.FullName, strings.ToLower(data.Type), data.Reference, data.Amount)
+	pushData := map[string]string{
+		"reference": data.Reference,
+		"type":      data.Type,
+		"amount":    strconv.FormatFloat(data.Amount, 'f', 2, 64),
+	}
+	if data.BalanceAfter != nil {
+		body += fmt.Sprintf(" Your balance is now %.2f.", *data.BalanceAfter)
+		pushData["balance"] = strconv.FormatFloat(*data.BalanceAfter, 'f', 2, 64)
 	}
-	log.Warn().
-		Str("status", resp.Status).
-		Msg("notification accepted but not finished yet")
 
-	return nil
+	req := &notificationpb.SendNotificationRequest{
+		Event:    "transaction_success",
+		UserId:   data.UserID,
+		Channels: []string{"email", "push"},
+		Payload: &notificationpb.NotificationPayload{
+			Email: &notificationpb.EmailPayload{
+				To:      data.Email,
+				Subject: "Transaction " + data.Reference + " successful",
+				Body:    body,
+			},
+			Push: &notificationpb.PushPayload{
+				Title: "Transaction successful",
+				Body:  body,
+				Data:  pushData,
+			},
+		},
+	}
+
+	resp, err := n.send(ctx, req)
+	if err != nil {
+		return fmt.Errorf("grpc send failed: %w", err)
+	}
+
+	return n.statusError(resp.Status)
+}
+
+func (n *NotificationClient) send(ctx context.Context, req *notificationpb.SendNotificationRequest) (*notificationpb.SendNotificationResponse, error) {
+	var resp *notificationpb.SendNotificationResponse
+	err := n.Breaker.Execute(ctx, "send_notification", func(ctx context.Context) error {
+		var err error
+		resp, err = n.Client.SendNotification(ctx, req)
+		return err
+	})
+	return resp, err
+}
 
+func (n *NotificationClient) statusError(status string) error {
+	switch strings.ToUpper(status) {
+	case "PENDING", "PROCESSING":
+		n.Logger.Warn("notification accepted but not finished yet", "status", status)
+		return nil
+	case "SUCCESS":
+		return nil
+	default:
+		return fmt.Errorf("notification rejected: %s", status)
+	}
 }
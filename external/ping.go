@@ -0,0 +1,70 @@
+package external
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// Ping* only check that the dependency is reachable, they bypass the circuit breakers
+// so health probes never trip or reset them.
+
+func (e *External) PingWallet(ctx context.Context) error {
+	if e.Wallet == nil {
+		return fmt.Errorf("wallet client not initialized")
+	}
+	return e.Wallet.Ping(ctx)
+}
+
+func (e *External) PingUMS(ctx context.Context) error {
+	if e.UMS == nil {
+		return fmt.Errorf("ums client not initialized")
+	}
+	return waitForReady(ctx, e.UMS.Conn)
+}
+
+func (e *External) PingNotification(ctx context.Context) error {
+	if e.NotificationClient == nil {
+		return fmt.Errorf("notification client not initialized")
+	}
+	return waitForReady(ctx, e.NotificationClient.Conn)
+}
+
+// Ping treats any non 5xx answer from the wallet health endpoint as reachable.
+func (w *WalletClient) Ping(ctx context.Context) error {
+	endpointURL, err := url.JoinPath(w.Config.Host, w.Config.HealthEndpoint)
+	if err != nil {
+		return errors.Wrap(err, "failed to build wallet url")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpointURL, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create wallet http request")
+	}
+	resp, err := w.HTTPClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect wallet service")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("got error response from wallet service: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func waitForReady(ctx context.Context, conn *grpc.ClientConn) error {
+	conn.Connect()
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("grpc connection not ready: %s", state)
+		}
+	}
+}
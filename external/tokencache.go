@@ -0,0 +1,95 @@
+package external
+
+import (
+	"container/list"
+	"ewallet-topup/internal/models"
+	"sync"
+	"time"
+)
+
+// tokenCache is a bounded LRU of validated tokens, keyed by the token hash so the
+// raw bearer token is never kept in memory longer than the request.
+type tokenCache struct {
+	mu      sync.Mutex
+	maxSize int
+	items   map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+}
+
+type tokenCacheEntry struct {
+	key       string
+	data      models.TokenData
+	expiresAt time.Time
+}
+
+func newTokenCache(maxSize int) *tokenCache {
+	return &tokenCache{
+		maxSize: maxSize,
+		items:   map[string]*list.Element{},
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+func (c *tokenCache) Get(key string) (models.TokenData, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return models.TokenData{}, false
+	}
+	entry := el.Value.(*tokenCacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.removeElement(el)
+		return models.TokenData{}, false
+	}
+	c.order.MoveToFront(el)
+	return entry.data, true
+}
+
+func (c *tokenCache) Set(key string, data models.TokenData, ttl time.Duration) {
+	if c.maxSize <= 0 || ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := c.now().Add(ttl)
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*tokenCacheEntry)
+		entry.data = data
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&tokenCacheEntry{key: key, data: data, expiresAt: expiresAt})
+	for c.order.Len() > c.maxSize {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *tokenCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *tokenCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = map[string]*list.Element{}
+	c.order.Init()
+}
+
+func (c *tokenCache) removeElement(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*tokenCacheEntry).key)
+}
@@ -2,34 +2,149 @@ package external
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	constants "ewallet-topup/constant"
 	"ewallet-topup/external/proto/tokenvalidation"
 	"ewallet-topup/helpers"
 	"ewallet-topup/internal/models"
-
 	"fmt"
+	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"golang.org/x/sync/singleflight"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 )
 
-func (*External) ValidateToken(ctx context.Context, token string) (models.TokenData, error) {
-	var (
-		resp models.TokenData
+// retry UNAVAILABLE only, ValidateToken has no side effect so it is safe to resend
+const umsServiceConfig = `{
+	"methodConfig": [{
+		"name": [{"service": "tokenvalidation.TokenValidation"}],
+		"retryPolicy": {
+			"maxAttempts": %d,
+			"initialBackoff": "0.1s",
+			"maxBackoff": "1s",
+			"backoffMultiplier": 2,
+			"retryableStatusCodes": ["UNAVAILABLE"]
+		}
+	}]
+}`
+
+type UMSConfig struct {
+	Host             string
+	Timeout          time.Duration
+	MaxAttempts      int
+	KeepaliveTime    time.Duration
+	KeepaliveTimeout time.Duration
+	CacheTTL         time.Duration
+	CacheSize        int
+	TLS              helpers.TLSConfig
+	Breaker          BreakerConfig
+}
+
+func LoadUMSConfig(env *helpers.Env) UMSConfig {
+	return UMSConfig{
+		Host:             env.Get("UMS_GRPC_HOST", ""),
+		Timeout:          env.GetDuration("UMS_TIMEOUT", 3*time.Second),
+		MaxAttempts:      env.GetInt("UMS_MAX_ATTEMPTS", 3),
+		KeepaliveTime:    env.GetDuration("UMS_KEEPALIVE_TIME", 30*time.Second),
+		KeepaliveTimeout: env.GetDuration("UMS_KEEPALIVE_TIMEOUT", 10*time.Second),
+		CacheTTL:         env.GetDuration("UMS_TOKEN_CACHE_TTL", time.Minute),
+		CacheSize:        env.GetInt("UMS_TOKEN_CACHE_SIZE", 10000),
+		TLS:              helpers.LoadTLSConfig(env, "UMS"),
+		Breaker:          LoadBreakerConfig(env, "UMS"),
+	}
+}
+
+// UMSClient keeps one grpc connection to UMS for the whole process lifetime.
+type UMSClient struct {
+	Conn    *grpc.ClientConn
+	Client  tokenvalidation.TokenValidationClient
+	Config  UMSConfig
+	Breaker *CircuitBreaker
+
+	cache *tokenCache
+	group singleflight.Group
+}
+
+func NewUMSClient(cfg UMSConfig, logger *slog.Logger) (*UMSClient, error) {
+	creds, err := cfg.TLS.ClientCredentials(logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load ums tls credentials")
+	}
+	conn, err := grpc.NewClient(cfg.Host,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                cfg.KeepaliveTime,
+			Timeout:             cfg.KeepaliveTimeout,
+			PermitWithoutStream: true,
+		}),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(umsServiceConfig, max(cfg.MaxAttempts, 1))),
 	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create ums grpc client")
+	}
+
+	return &UMSClient{
+		Conn:    conn,
+		Client:  tokenvalidation.NewTokenValidationClient(conn),
+		Config:  cfg,
+		Breaker: NewCircuitBreaker("ums", cfg.Breaker, isGRPCUnavailable),
+		cache:   newTokenCache(cfg.CacheSize),
+	}, nil
+}
 
-	conn, err := grpc.Dial(helpers.GetEnv("UMS_GRPC_HOST", ""), grpc.WithInsecure())
+func (u *UMSClient) Close() error {
+	return u.Conn.Close()
+}
+
+func (u *UMSClient) ValidateToken(ctx context.Context, token string) (models.TokenData, error) {
+	key := tokenHash(token)
+	if data, ok := u.cache.Get(key); ok {
+		return data, nil
+	}
+
+	// concurrent requests with the same token share one UMS call
+	result, err, _ := u.group.Do(key, func() (interface{}, error) {
+		callCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), u.Config.Timeout)
+		defer cancel()
+
+		data, err := u.validateToken(callCtx, token)
+		if err != nil {
+			return data, err
+		}
+		u.cache.Set(key, data, u.cacheTTL(token))
+		return data, nil
+	})
 	if err != nil {
-		return resp, errors.Wrap(err, "failed to dial ums grpc")
+		return models.TokenData{}, err
 	}
-	defer conn.Close()
+	return result.(models.TokenData), nil
+}
 
-	client := tokenvalidation.NewTokenValidationClient(conn)
+func (u *UMSClient) validateToken(ctx context.Context, token string) (models.TokenData, error) {
+	var (
+		resp models.TokenData
+	)
 
 	req := &tokenvalidation.TokenRequest{
 		Token: token,
 	}
-	response, err := client.ValidateToken(ctx, req)
+	var response *tokenvalidation.TokenResponse
+	err := u.Breaker.Execute(ctx, "validate_token", func(ctx context.Context) error {
+		var err error
+		response, err = u.Client.ValidateToken(ctx, req)
+		return err
+	})
 	if err != nil {
 		return resp, errors.Wrap(err, "failed to validate token")
 	}
@@ -44,3 +159,75 @@ func (*External) ValidateToken(ctx context.Context, token string) (models.TokenD
 
 	return resp, nil
 }
+
+// InvalidateToken drops a token from the cache, e.g. after logout or a 401 from a downstream service.
+func (u *UMSClient) InvalidateToken(token string) {
+	u.cache.Delete(tokenHash(token))
+}
+
+func (u *UMSClient) InvalidateAll() {
+	u.cache.Clear()
+}
+
+// cacheTTL never keeps a token past its own exp claim.
+func (u *UMSClient) cacheTTL(token string) time.Duration {
+	ttl := u.Config.CacheTTL
+	exp, ok := tokenExpiry(token)
+	if !ok {
+		return ttl
+	}
+	if untilExp := time.Until(exp); untilExp < ttl {
+		return untilExp
+	}
+	return ttl
+}
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(strings.TrimPrefix(token, "Bearer ")))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenExpiry reads exp from a JWT without checking the signature, UMS already did that.
+func tokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	claims := struct {
+		Exp int64 `json:"exp"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+func (e *External) ValidateToken(ctx context.Context, token string) (models.TokenData, error) {
+	if e.UMS == nil {
+		return models.TokenData{}, fmt.Errorf("ums client not initialized")
+	}
+	return e.UMS.ValidateToken(ctx, token)
+}
+
+func (e *External) InvalidateToken(token string) {
+	if e.UMS == nil {
+		return
+	}
+	e.UMS.InvalidateToken(token)
+}
+
+// isGRPCUnavailable reports errors that mean the dependency itself is unhealthy.
+func isGRPCUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return true
+	}
+	return false
+}
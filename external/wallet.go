@@ -6,87 +6,442 @@ import (
 	"encoding/json"
 	"ewallet-topup/helpers"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
+var (
+	ErrInsufficientBalance = errors.New("wallet: insufficient balance")
+	ErrUnauthorized        = errors.New("wallet: unauthorized")
+	ErrDuplicateReference  = errors.New("wallet: duplicate reference")
+	ErrWalletTransient     = errors.New("wallet: transient failure")
+	// ErrHoldReleased is returned for a reference whose hold was already captured or voided
+	ErrHoldReleased = errors.New("wallet: hold already released")
+)
+
+// error codes the wallet puts next to the message of a rejected request
+const (
+	WalletCodeInsufficientBalance = "INSUFFICIENT_BALANCE"
+	WalletCodeDuplicateReference  = "DUPLICATE_REFERENCE"
+)
+
+const (
+	HoldStatusHeld     = "HELD"
+	HoldStatusCaptured = "CAPTURED"
+	HoldStatusVoided   = "VOIDED"
+)
+
+// WalletError keeps the wallet status code and message next to the error kind,
+// so callers can use errors.Is against the Err* values above.
+type WalletError struct {
+	Kind       error
+	StatusCode int
+	Code       string
+	Message    string
+	Err        error
+}
+
+func (e *WalletError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Kind, e.Err)
+	}
+	return fmt.Sprintf("%s: status %d: %s", e.Kind, e.StatusCode, e.Message)
+}
+
+func (e *WalletError) Unwrap() []error {
+	if e.Err != nil {
+		return []error{e.Kind, e.Err}
+	}
+	return []error{e.Kind}
+}
+
+// WalletErrorResponse is the body of a non-2xx wallet response.
+type WalletErrorResponse struct {
+	Message string `json:"message"`
+	Code    string `json:"code"`
+}
+
 type UpdateBalance struct {
 	Reference string  `json:"reference"`
 	Amount    float64 `json:"amount"`
+	UserID    int64   `json:"user_id,omitempty"`
+}
+
+type WalletBalance struct {
+	Balance float64 `json:"balance"`
 }
 
 type UpdateBalanceResponse struct {
-	Message string `json:"message"`
-	Data    struct {
-		Balance float64 `json:"balance"`
-	} `json:"data"`
+	Message string        `json:"message"`
+	Data    WalletBalance `json:"data"`
+}
+
+type BalanceResponse struct {
+	Message string        `json:"message"`
+	Data    WalletBalance `json:"data"`
+}
+
+type HoldRequest struct {
+	Reference string  `json:"reference"`
+	Amount    float64 `json:"amount"`
+	UserID    int64   `json:"user_id,omitempty"`
+}
+
+type HoldActionRequest struct {
+	Reference string `json:"reference"`
+	UserID    int64  `json:"user_id,omitempty"`
+}
+
+type WalletHold struct {
+	HoldID  string  `json:"hold_id"`
+	Status  string  `json:"status"`
+	Balance float64 `json:"balance"`
+}
+
+type HoldResponse struct {
+	Message string     `json:"message"`
+	Data    WalletHold `json:"data"`
+}
+
+type WalletConfig struct {
+	Host            string
+	CreditEndpoint  string
+	DebitEndpoint   string
+	BalanceEndpoint string
+	HoldEndpoint    string
+	HealthEndpoint  string
+	Timeout         time.Duration
+	DialTimeout     time.Duration
+	IdleConnTimeout time.Duration
+	MaxIdleConns    int
+	MaxConnsPerHost int
+	Breaker         BreakerConfig
+}
+
+func LoadWalletConfig(env *helpers.Env) WalletConfig {
+	return WalletConfig{
+		Host:            env.Get("WALLET_HOST", "http://localhost:8085"),
+		CreditEndpoint:  env.Get("WALLET_ENDPOINT_CREDIT", "/wallet/v1/balance/credit"),
+		DebitEndpoint:   env.Get("WALLET_ENDPOINT_DEBIT", "/wallet/v1/balance/debit"),
+		BalanceEndpoint: env.Get("WALLET_ENDPOINT_BALANCE", "/wallet/v1/balance"),
+		HoldEndpoint:    env.Get("WALLET_ENDPOINT_HOLD", "/wallet/v1/holds"),
+		HealthEndpoint:  env.Get("WALLET_ENDPOINT_HEALTH", "/health"),
+		Timeout:         env.GetDuration("WALLET_TIMEOUT", 10*time.Second),
+		DialTimeout:     env.GetDuration("WALLET_DIAL_TIMEOUT", 3*time.Second),
+		IdleConnTimeout: env.GetDuration("WALLET_IDLE_CONN_TIMEOUT", 90*time.Second),
+		MaxIdleConns:    env.GetInt("WALLET_MAX_IDLE_CONNS", 50),
+		MaxConnsPerHost: env.GetInt("WALLET_MAX_CONNS_PER_HOST", 100),
+		Breaker:         LoadBreakerConfig(env, "WALLET"),
+	}
+}
+
+type WalletClient struct {
+	Config       WalletConfig
+	HTTPClient   *http.Client
+	ServiceToken *ServiceTokenSigner
+	Breaker      *CircuitBreaker
 }
 
-func (e *External) CreditBalance(ctx context.Context, token string, req UpdateBalance) (*UpdateBalanceResponse, error) {
-	payload, err := json.Marshal(req)
+// NewWalletClient builds one pooled http client that is shared by every wallet call.
+func NewWalletClient(cfg WalletConfig, serviceToken *ServiceTokenSigner) *WalletClient {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   cfg.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConns,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   cfg.DialTimeout,
+		ResponseHeaderTimeout: cfg.Timeout,
+	}
+	return &WalletClient{
+		Config: cfg,
+		HTTPClient: &http.Client{
+			Transport: otelhttp.NewTransport(transport),
+			Timeout:   cfg.Timeout,
+		},
+		ServiceToken: serviceToken,
+		// only outages trip the breaker, business rejections like insufficient balance do not
+		Breaker: NewCircuitBreaker("wallet", cfg.Breaker, func(err error) bool {
+			return errors.Is(err, ErrWalletTransient)
+		}),
+	}
+}
+
+// serviceToken mints the internal token used for wallet mutations done by the worker.
+func (w *WalletClient) serviceToken(userID int64) (string, error) {
+	if w.ServiceToken == nil {
+		return "", errors.New("wallet service token signer not initialized")
+	}
+	return w.ServiceToken.Mint(userID)
+}
+
+func (w *WalletClient) Credit(ctx context.Context, req UpdateBalance) (*UpdateBalanceResponse, error) {
+	token, err := w.serviceToken(req.UserID)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to marhsal json")
+		return nil, err
 	}
+	result := &UpdateBalanceResponse{}
+	err = w.do(ctx, "credit", http.MethodPut, w.Config.CreditEndpoint, token, req, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
 
-	url := helpers.GetEnv("WALLET_HOST", "") + helpers.GetEnv("WALLET_ENDPOINT_CREDIT", "")
+func (w *WalletClient) Debit(ctx context.Context, req UpdateBalance) (*UpdateBalanceResponse, error) {
+	token, err := w.serviceToken(req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	result := &UpdateBalanceResponse{}
+	err = w.do(ctx, "debit", http.MethodPut, w.Config.DebitEndpoint, token, req, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
 
-	httpReq, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(payload))
+func (w *WalletClient) Balance(ctx context.Context, token string) (*BalanceResponse, error) {
+	result := &BalanceResponse{}
+	err := w.do(ctx, "balance", http.MethodGet, w.Config.BalanceEndpoint, token, nil, result)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create wallet http request")
+		return nil, err
 	}
-	httpReq.Header.Set("Authorization", token)
+	return result, nil
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
+// Hold reserves the amount on the wallet without debiting it yet.
+func (w *WalletClient) Hold(ctx context.Context, req HoldRequest) (*HoldResponse, error) {
+	token, err := w.serviceToken(req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	result := &HoldResponse{}
+	err = w.do(ctx, "hold", http.MethodPost, w.Config.HoldEndpoint, token, req, result)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to connect wallet service")
+		return nil, err
 	}
+	return result, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("got error response from wallet service: %d", resp.StatusCode)
+// FindHold returns the hold placed for req.Reference, e.g. when a retried Hold is rejected as
+// a duplicate because the first attempt went through.
+func (w *WalletClient) FindHold(ctx context.Context, req HoldActionRequest) (*HoldResponse, error) {
+	token, err := w.serviceToken(req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	result := &HoldResponse{}
+	endpoint, err := url.JoinPath(w.Config.HoldEndpoint, "reference", pathSegment(req.Reference))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build wallet url")
+	}
+	err = w.do(ctx, "find_hold", http.MethodGet, endpoint, token, nil, result)
+	if err != nil {
+		return nil, err
 	}
+	return result, nil
+}
 
-	result := &UpdateBalanceResponse{}
-	err = json.NewDecoder(resp.Body).Decode(result)
+// Capture debits the amount reserved by Hold.
+func (w *WalletClient) Capture(ctx context.Context, holdID string, req HoldActionRequest) (*HoldResponse, error) {
+	token, err := w.serviceToken(req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	result := &HoldResponse{}
+	endpoint, err := url.JoinPath(w.Config.HoldEndpoint, pathSegment(holdID), "capture")
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to read response body")
+		return nil, errors.Wrap(err, "failed to build wallet url")
 	}
-	defer resp.Body.Close()
+	err = w.do(ctx, "capture", http.MethodPost, endpoint, token, req, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
 
+// Void releases the amount reserved by Hold back to the wallet.
+func (w *WalletClient) Void(ctx context.Context, holdID string, req HoldActionRequest) (*HoldResponse, error) {
+	token, err := w.serviceToken(req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	result := &HoldResponse{}
+	endpoint, err := url.JoinPath(w.Config.HoldEndpoint, pathSegment(holdID), "void")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build wallet url")
+	}
+	err = w.do(ctx, "void", http.MethodPost, endpoint, token, req, result)
+	if err != nil {
+		return nil, err
+	}
 	return result, nil
 }
 
-func (e *External) DebitBalance(ctx context.Context, token string, req UpdateBalance) (*UpdateBalanceResponse, error) {
-	payload, err := json.Marshal(req)
+func (w *WalletClient) do(ctx context.Context, operation, method, endpoint, token string, body interface{}, out interface{}) error {
+	return w.Breaker.Execute(ctx, operation, func(ctx context.Context) error {
+		return w.doRequest(ctx, method, endpoint, token, body, out)
+	})
+}
+
+func (w *WalletClient) doRequest(ctx context.Context, method, endpoint, token string, body interface{}, out interface{}) error {
+	endpointURL, err := url.JoinPath(w.Config.Host, endpoint)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to marhsal json")
+		return errors.Wrap(err, "failed to build wallet url")
 	}
 
-	url := helpers.GetEnv("WALLET_HOST", "") + helpers.GetEnv("WALLET_ENDPOINT_DEBIT", "")
+	var reqBody io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal json")
+		}
+		reqBody = bytes.NewReader(payload)
+	}
 
-	httpReq, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(payload))
+	httpReq, err := http.NewRequestWithContext(ctx, method, endpointURL, reqBody)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create wallet http request")
+		return errors.Wrap(err, "failed to create wallet http request")
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		httpReq.Header.Set("Authorization", bearer(token))
 	}
-	httpReq.Header.Set("Authorization", token)
 
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
+	resp, err := w.HTTPClient.Do(httpReq)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to connect wallet service")
+		return &WalletError{Kind: ErrWalletTransient, Err: errors.Wrap(err, "failed to connect wallet service")}
 	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("got error response from wallet service: %d", resp.StatusCode)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return walletStatusError(resp)
 	}
 
-	result := &UpdateBalanceResponse{}
-	err = json.NewDecoder(resp.Body).Decode(result)
+	if out == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(out)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to read response body")
+		return errors.Wrap(err, "failed to read response body")
 	}
-	defer resp.Body.Close()
+	return nil
+}
 
-	return result, nil
+// walletStatusError maps a non-2xx wallet response to a typed error. The error code wins
+// over the status, the message is only kept for logs.
+func walletStatusError(resp *http.Response) error {
+	var errResp WalletErrorResponse
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err := json.Unmarshal(raw, &errResp); err != nil || errResp.Message == "" {
+		errResp.Message = strings.TrimSpace(string(raw))
+	}
+
+	walletErr := &WalletError{
+		StatusCode: resp.StatusCode,
+		Code:       errResp.Code,
+		Message:    errResp.Message,
+	}
+	switch {
+	case errResp.Code == WalletCodeDuplicateReference:
+		walletErr.Kind = ErrDuplicateReference
+	case errResp.Code == WalletCodeInsufficientBalance:
+		walletErr.Kind = ErrInsufficientBalance
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		walletErr.Kind = ErrUnauthorized
+	case resp.StatusCode == http.StatusConflict:
+		walletErr.Kind = ErrDuplicateReference
+	case resp.StatusCode == http.StatusPaymentRequired:
+		walletErr.Kind = ErrInsufficientBalance
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		walletErr.Kind = ErrWalletTransient
+	default:
+		walletErr.Kind = fmt.Errorf("wallet: got error response %d", resp.StatusCode)
+	}
+	return walletErr
+}
+
+// pathSegment escapes s for use as one element of url.JoinPath, which takes its elements as
+// already escaped: a "/" in s would add a segment and "." or ".." would be resolved away.
+// doRequest joins the result onto Host with url.JoinPath too, so the escapes are kept as is.
+func pathSegment(s string) string {
+	if s == "." || s == ".." {
+		return strings.ReplaceAll(s, ".", "%2E")
+	}
+	return url.PathEscape(s)
+}
+
+func bearer(token string) string {
+	const prefix = "Bearer "
+	if strings.HasPrefix(token, prefix) {
+		return token
+	}
+	return prefix + token
+}
+
+func (e *External) CreditBalance(ctx context.Context, req UpdateBalance) (*UpdateBalanceResponse, error) {
+	if e.Wallet == nil {
+		return nil, fmt.Errorf("wallet client not initialized")
+	}
+	return e.Wallet.Credit(ctx, req)
+}
+
+func (e *External) DebitBalance(ctx context.Context, req UpdateBalance) (*UpdateBalanceResponse, error) {
+	if e.Wallet == nil {
+		return nil, fmt.Errorf("wallet client not initialized")
+	}
+	return e.Wallet.Debit(ctx, req)
+}
+
+func (e *External) GetBalance(ctx context.Context, token string) (*BalanceResponse, error) {
+	if e.Wallet == nil {
+		return nil, fmt.Errorf("wallet client not initialized")
+	}
+	return e.Wallet.Balance(ctx, token)
+}
+
+func (e *External) HoldBalance(ctx context.Context, req HoldRequest) (*HoldResponse, error) {
+	if e.Wallet == nil {
+		return nil, fmt.Errorf("wallet client not initialized")
+	}
+	return e.Wallet.Hold(ctx, req)
+}
+
+func (e *External) FindHold(ctx context.Context, req HoldActionRequest) (*HoldResponse, error) {
+	if e.Wallet == nil {
+		return nil, fmt.Errorf("wallet client not initialized")
+	}
+	return e.Wallet.FindHold(ctx, req)
+}
+
+func (e *External) CaptureHold(ctx context.Context, holdID string, req HoldActionRequest) (*HoldResponse, error) {
+	if e.Wallet == nil {
+		return nil, fmt.Errorf("wallet client not initialized")
+	}
+	return e.Wallet.Capture(ctx, holdID, req)
+}
+
+func (e *External) VoidHold(ctx context.Context, holdID string, req HoldActionRequest) (*HoldResponse, error) {
+	if e.Wallet == nil {
+		return nil, fmt.Errorf("wallet client not initialized")
+	}
+	return e.Wallet.Void(ctx, holdID, req)
 }
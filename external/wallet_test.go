@@ -0,0 +1,252 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func newTestWallet(t *testing.T, handler http.HandlerFunc) *WalletClient {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	cfg := WalletConfig{
+		Host:            srv.URL,
+		CreditEndpoint:  "/wallet/v1/balance/credit",
+		DebitEndpoint:   "/wallet/v1/balance/debit",
+		BalanceEndpoint: "/wallet/v1/balance",
+		HoldEndpoint:    "/wallet/v1/holds",
+		Timeout:         time.Second,
+		DialTimeout:     time.Second,
+		IdleConnTimeout: time.Minute,
+		MaxIdleConns:    4,
+		MaxConnsPerHost: 4,
+		Breaker: BreakerConfig{
+			FailureThreshold: 3,
+			OpenTimeout:      time.Minute,
+			HalfOpenMaxCalls: 1,
+			MaxConcurrent:    4,
+			BulkheadWait:     time.Second,
+		},
+	}
+	signer := NewServiceTokenSigner(ServiceTokenConfig{
+		Issuer:   "ewallet-topup",
+		Audience: "ewallet-wallet",
+		Secret:   []byte("test-secret"),
+		TTL:      time.Minute,
+	})
+	return NewWalletClient(cfg, signer)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func TestWalletClientCredit(t *testing.T) {
+	wallet := newTestWallet(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/wallet/v1/balance/credit" {
+			t.Errorf("got %s %s, want PUT /wallet/v1/balance/credit", r.Method, r.URL.Path)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		if auth := r.Header.Get("Authorization"); !strings.HasPrefix(auth, "Bearer ") {
+			t.Errorf("Authorization = %q, want a bearer service token", auth)
+		}
+		var req UpdateBalance
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		if req.Reference != "ref-1" || req.Amount != 100 || req.UserID != 7 {
+			t.Errorf("request = %+v", req)
+		}
+		writeJSON(w, http.StatusOK, UpdateBalanceResponse{Message: "success", Data: WalletBalance{Balance: 1100}})
+	})
+
+	resp, err := wallet.Credit(context.Background(), UpdateBalance{Reference: "ref-1", Amount: 100, UserID: 7})
+	if err != nil {
+		t.Fatalf("Credit: %v", err)
+	}
+	if resp.Data.Balance != 1100 {
+		t.Errorf("balance = %v, want 1100", resp.Data.Balance)
+	}
+}
+
+func TestWalletClientTypedErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   interface{}
+		want   error
+	}{
+		{"insufficient by code", http.StatusUnprocessableEntity, WalletErrorResponse{Message: "saldo kurang", Code: WalletCodeInsufficientBalance}, ErrInsufficientBalance},
+		{"insufficient by status", http.StatusPaymentRequired, WalletErrorResponse{Message: "no funds"}, ErrInsufficientBalance},
+		{"duplicate by code", http.StatusBadRequest, WalletErrorResponse{Message: "sudah diproses", Code: WalletCodeDuplicateReference}, ErrDuplicateReference},
+		{"duplicate by status", http.StatusConflict, WalletErrorResponse{Message: "already exists"}, ErrDuplicateReference},
+		{"unauthorized", http.StatusUnauthorized, WalletErrorResponse{Message: "bad token"}, ErrUnauthorized},
+		{"forbidden", http.StatusForbidden, WalletErrorResponse{Message: "not yours"}, ErrUnauthorized},
+		{"server error", http.StatusBadGateway, "upstream down", ErrWalletTransient},
+		{"rate limited", http.StatusTooManyRequests, WalletErrorResponse{Message: "slow down"}, ErrWalletTransient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wallet := newTestWallet(t, func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, tt.status, tt.body)
+			})
+
+			_, err := wallet.Debit(context.Background(), UpdateBalance{Reference: "ref-1", Amount: 100, UserID: 7})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Debit error = %v, want %v", err, tt.want)
+			}
+			var walletErr *WalletError
+			if !errors.As(err, &walletErr) || walletErr.StatusCode != tt.status {
+				t.Errorf("error %v does not carry status %d", err, tt.status)
+			}
+		})
+	}
+}
+
+func TestWalletClientMessageDoesNotClassify(t *testing.T) {
+	wallet := newTestWallet(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusBadRequest, WalletErrorResponse{Message: "duplicate field, insufficient data"})
+	})
+
+	_, err := wallet.Debit(context.Background(), UpdateBalance{Reference: "ref-1", Amount: 100, UserID: 7})
+	if err == nil || errors.Is(err, ErrDuplicateReference) || errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("Debit error = %v, want an unclassified error", err)
+	}
+}
+
+// An activity retry repeats the call with the same reference: a transient failure first, then
+// success, then the wallet rejecting the reference it already applied.
+func TestWalletClientRetrySameReference(t *testing.T) {
+	var calls atomic.Int32
+	wallet := newTestWallet(t, func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			writeJSON(w, http.StatusServiceUnavailable, WalletErrorResponse{Message: "try again"})
+		case 2:
+			writeJSON(w, http.StatusOK, UpdateBalanceResponse{Message: "success", Data: WalletBalance{Balance: 900}})
+		default:
+			writeJSON(w, http.StatusConflict, WalletErrorResponse{Message: "reference used", Code: WalletCodeDuplicateReference})
+		}
+	})
+	req := UpdateBalance{Reference: "ref-1", Amount: 100, UserID: 7}
+
+	if _, err := wallet.Debit(context.Background(), req); !errors.Is(err, ErrWalletTransient) {
+		t.Fatalf("first attempt error = %v, want transient", err)
+	}
+	resp, err := wallet.Debit(context.Background(), req)
+	if err != nil || resp.Data.Balance != 900 {
+		t.Fatalf("second attempt = %+v, %v", resp, err)
+	}
+	if _, err := wallet.Debit(context.Background(), req); !errors.Is(err, ErrDuplicateReference) {
+		t.Fatalf("third attempt error = %v, want duplicate reference", err)
+	}
+}
+
+func TestWalletClientConnectionErrorIsTransient(t *testing.T) {
+	wallet := newTestWallet(t, func(w http.ResponseWriter, r *http.Request) {})
+	wallet.Config.Host = "http://127.0.0.1:1"
+
+	_, err := wallet.Credit(context.Background(), UpdateBalance{Reference: "ref-1", Amount: 100, UserID: 7})
+	if !errors.Is(err, ErrWalletTransient) {
+		t.Fatalf("Credit error = %v, want transient", err)
+	}
+}
+
+func TestWalletClientBreakerOpensOnTransientFailures(t *testing.T) {
+	var calls atomic.Int32
+	wallet := newTestWallet(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		writeJSON(w, http.StatusInternalServerError, WalletErrorResponse{Message: "boom"})
+	})
+	req := UpdateBalance{Reference: "ref-1", Amount: 100, UserID: 7}
+
+	for i := 0; i < wallet.Config.Breaker.FailureThreshold; i++ {
+		if _, err := wallet.Credit(context.Background(), req); !errors.Is(err, ErrWalletTransient) {
+			t.Fatalf("call %d error = %v, want transient", i, err)
+		}
+	}
+	if _, err := wallet.Credit(context.Background(), req); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("error = %v, want circuit open", err)
+	}
+	if got := calls.Load(); got != int32(wallet.Config.Breaker.FailureThreshold) {
+		t.Errorf("wallet got %d calls, want %d", got, wallet.Config.Breaker.FailureThreshold)
+	}
+}
+
+func TestWalletClientBusinessErrorsKeepBreakerClosed(t *testing.T) {
+	wallet := newTestWallet(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusUnprocessableEntity, WalletErrorResponse{Message: "no", Code: WalletCodeInsufficientBalance})
+	})
+
+	for i := 0; i < wallet.Config.Breaker.FailureThreshold+1; i++ {
+		_, _ = wallet.Debit(context.Background(), UpdateBalance{Reference: "ref-1", Amount: 100, UserID: 7})
+	}
+	if state := wallet.Breaker.State(); state != BreakerClosed {
+		t.Errorf("breaker state = %s, want closed", state)
+	}
+}
+
+func TestWalletClientHoldActions(t *testing.T) {
+	var paths []string
+	wallet := newTestWallet(t, func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.EscapedPath())
+		writeJSON(w, http.StatusOK, HoldResponse{Message: "success", Data: WalletHold{HoldID: "hold 1", Status: "HELD"}})
+	})
+	ctx := context.Background()
+
+	if _, err := wallet.Hold(ctx, HoldRequest{Reference: "ref-1", Amount: 100, UserID: 7}); err != nil {
+		t.Fatalf("Hold: %v", err)
+	}
+	if _, err := wallet.FindHold(ctx, HoldActionRequest{Reference: "ref-1", UserID: 7}); err != nil {
+		t.Fatalf("FindHold: %v", err)
+	}
+	if _, err := wallet.Capture(ctx, "hold 1", HoldActionRequest{Reference: "ref-1", UserID: 7}); err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+	if _, err := wallet.Void(ctx, "hold 1", HoldActionRequest{Reference: "ref-1", UserID: 7}); err != nil {
+		t.Fatalf("Void: %v", err)
+	}
+
+	want := []string{
+		"POST /wallet/v1/holds",
+		"GET /wallet/v1/holds/reference/ref-1",
+		"POST /wallet/v1/holds/hold%201/capture",
+		"POST /wallet/v1/holds/hold%201/void",
+	}
+	if strings.Join(paths, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests:\n%s\nwant:\n%s", strings.Join(paths, "\n"), strings.Join(want, "\n"))
+	}
+
+	// ids are escaped as one segment, so they can't reach another wallet path
+	paths = nil
+	if _, err := wallet.FindHold(ctx, HoldActionRequest{Reference: "../ref/2", UserID: 7}); err != nil {
+		t.Fatalf("FindHold: %v", err)
+	}
+	if _, err := wallet.Capture(ctx, "..", HoldActionRequest{Reference: "ref-2", UserID: 7}); err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+	if _, err := wallet.Void(ctx, "hold/../1", HoldActionRequest{Reference: "ref-2", UserID: 7}); err != nil {
+		t.Fatalf("Void: %v", err)
+	}
+	want = []string{
+		"GET /wallet/v1/holds/reference/..%2Fref%2F2",
+		"POST /wallet/v1/holds/%2E%2E/capture",
+		"POST /wallet/v1/holds/hold%2F..%2F1/void",
+	}
+	if strings.Join(paths, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests:\n%s\nwant:\n%s", strings.Join(paths, "\n"), strings.Join(want, "\n"))
+	}
+}
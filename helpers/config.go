@@ -1,26 +1,188 @@
 package helpers
 
 import (
-	"log"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
-var Env = map[string]string{}
+// Env holds the config values SetupConfig layered together. The Get* methods read them with
+// a default, values that do not parse are collected for config validation, see Errors.
+type Env struct {
+	values map[string]string
+	errs   []error
+}
+
+// NewEnv wraps values as they are, without reading files or the environment, e.g. for tests.
+func NewEnv(values map[string]string) *Env {
+	if values == nil {
+		values = map[string]string{}
+	}
+	return &Env{values: values}
+}
+
+// ConfigFlags are the config options every command accepts, see SetupConfig.
+type ConfigFlags struct {
+	Profile string
+	File    string
+	Set     stringList
+}
+
+func (f *ConfigFlags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.Profile, "profile", "", "config profile: local, staging or prod (default APP_ENV or local)")
+	fs.StringVar(&f.File, "config", "", "env file to load (default .env)")
+	fs.Var(&f.Set, "set", "override one value, KEY=VALUE, can be repeated")
+}
+
+// SetupConfig reads the config values from, lowest priority first: the config file (-config,
+// default .env), the profile file config/<profile>.env, real environment variables and
+// -set KEY=VALUE flags. The profile file wins over the config file so a local .env cannot
+// loosen a prod or staging profile. Defaults stay in the Load*Config functions. Every file is
+// optional except an explicit -config.
+func SetupConfig(opts ConfigFlags) (*Env, error) {
+	file, err := readEnvFile(opts.File, ".env")
+	if err != nil {
+		return nil, err
+	}
+	process := processEnv()
+	flags := map[string]string{}
+	for _, kv := range opts.Set {
+		key, val, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid -set %q, expected KEY=VALUE", kv)
+		}
+		flags[key] = val
+	}
+
+	profile := opts.Profile
+	if profile == "" {
+		profile = firstNonEmpty(flags["APP_ENV"], process["APP_ENV"], file["APP_ENV"], "local")
+	}
+	profileFile, err := readEnvFile("", "config/"+profile+".env")
+	if err != nil {
+		return nil, err
+	}
 
-func SetupConfig() {
-	var err error
-	Env, err = godotenv.Read(".env")
+	values := map[string]string{}
+	for _, layer := range []map[string]string{file, profileFile, process, flags} {
+		for k, v := range layer {
+			values[k] = v
+		}
+	}
+	values["APP_ENV"] = profile
+	return NewEnv(values), nil
+}
+
+// readEnvFile reads path, or fallback when path is empty. Only a missing fallback is ignored.
+func readEnvFile(path, fallback string) (map[string]string, error) {
+	if path == "" {
+		if _, err := os.Stat(fallback); os.IsNotExist(err) {
+			return map[string]string{}, nil
+		}
+		path = fallback
+	}
+	values, err := godotenv.Read(path)
 	if err != nil {
-		log.Fatal("failed to read env file: ", err)
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+func processEnv() map[string]string {
+	values := map[string]string{}
+	for _, kv := range os.Environ() {
+		if key, val, ok := strings.Cut(kv, "="); ok {
+			values[key] = val
+		}
 	}
+	return values
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+type stringList []string
+
+func (s *stringList) String() string     { return strings.Join(*s, ",") }
+func (s *stringList) Set(v string) error { *s = append(*s, v); return nil }
+
+// Errors returns the values GetInt, GetDuration, GetBool and GetFloat could not parse.
+func (e *Env) Errors() []error {
+	return e.errs
+}
+
+func (e *Env) invalid(format string, args ...any) {
+	e.errs = append(e.errs, fmt.Errorf(format, args...))
 }
 
-func GetEnv(key string, val string) string {
+func (e *Env) Get(key string, val string) string {
 	// val is used as default value
-	result := Env[key]
+	result := e.values[key]
 	if result == "" {
 		result = val
 	}
 	return result
 }
+
+func (e *Env) GetInt(key string, val int) int {
+	raw := e.Get(key, "")
+	if raw == "" {
+		return val
+	}
+	result, err := strconv.Atoi(raw)
+	if err != nil {
+		e.invalid("%s: %q is not an integer", key, raw)
+		return val
+	}
+	return result
+}
+
+func (e *Env) GetDuration(key string, val time.Duration) time.Duration {
+	raw := e.Get(key, "")
+	if raw == "" {
+		return val
+	}
+	result, err := time.ParseDuration(raw)
+	if err != nil {
+		e.invalid("%s: %q is not a duration, use e.g. 500ms, 30s, 5m", key, raw)
+		return val
+	}
+	return result
+}
+
+func (e *Env) GetBool(key string, val bool) bool {
+	raw := e.Get(key, "")
+	if raw == "" {
+		return val
+	}
+	result, err := strconv.ParseBool(raw)
+	if err != nil {
+		e.invalid("%s: %q is not a boolean", key, raw)
+		return val
+	}
+	return result
+}
+
+func (e *Env) GetFloat(key string, val float64) float64 {
+	raw := e.Get(key, "")
+	if raw == "" {
+		return val
+	}
+	result, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		e.invalid("%s: %q is not a number", key, raw)
+		return val
+	}
+	return result
+}
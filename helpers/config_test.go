@@ -0,0 +1,134 @@
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvDefaultsAndParseErrors(t *testing.T) {
+	env := NewEnv(map[string]string{
+		"PORT":    "8080",
+		"RETRIES": "three",
+		"TIMEOUT": "5",
+		"DEBUG":   "yes please",
+		"RATIO":   "half",
+		"EMPTY":   "",
+	})
+
+	if got := env.Get("PORT", "80"); got != "8080" {
+		t.Errorf("Get(PORT) = %q", got)
+	}
+	if got := env.Get("EMPTY", "fallback"); got != "fallback" {
+		t.Errorf("Get(EMPTY) = %q, an empty value takes the default", got)
+	}
+	if got := env.GetInt("RETRIES", 3); got != 3 {
+		t.Errorf("GetInt(RETRIES) = %d, want the default", got)
+	}
+	if got := env.GetDuration("TIMEOUT", time.Second); got != time.Second {
+		t.Errorf("GetDuration(TIMEOUT) = %s, want the default", got)
+	}
+	if got := env.GetBool("DEBUG", false); got {
+		t.Error("GetBool(DEBUG) = true, want the default")
+	}
+	if got := env.GetFloat("RATIO", 1); got != 1 {
+		t.Errorf("GetFloat(RATIO) = %v, want the default", got)
+	}
+
+	var keys []string
+	for _, err := range env.Errors() {
+		keys = append(keys, strings.SplitN(err.Error(), ":", 2)[0])
+	}
+	if got := strings.Join(keys, ","); got != "RETRIES,TIMEOUT,DEBUG,RATIO" {
+		t.Errorf("errors for %s, want RETRIES,TIMEOUT,DEBUG,RATIO", got)
+	}
+}
+
+func TestEnvErrorsAreNotShared(t *testing.T) {
+	bad := NewEnv(map[string]string{"GRPC_TLS_RELOAD_INTERVAL": "soon", "LOG_LEVEL": "loud"})
+	good := NewEnv(nil)
+
+	LoadTLSConfig(bad, "UMS")
+	LoadLogConfig(bad)
+	LoadTLSConfig(good, "UMS")
+	LoadLogConfig(good)
+
+	if n := len(bad.Errors()); n != 2 {
+		t.Errorf("bad env has %d errors, want 2: %v", n, bad.Errors())
+	}
+	if n := len(good.Errors()); n != 0 {
+		t.Errorf("good env has %d errors, want none: %v", n, good.Errors())
+	}
+}
+
+func TestSetupConfigLayers(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.Mkdir("config", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("config/staging.env", "FROM_PROFILE=profile\nFROM_PROCESS=profile\nFROM_FLAG=profile\n")
+	write(".env", "APP_ENV=staging\nFROM_FILE=file\nFROM_PROFILE=file\nFROM_PROCESS=file\nFROM_FLAG=file\n")
+	t.Setenv("FROM_PROCESS", "process")
+	t.Setenv("FROM_FLAG", "process")
+
+	env, err := SetupConfig(ConfigFlags{Set: stringList{"FROM_FLAG=flag"}})
+	if err != nil {
+		t.Fatalf("SetupConfig: %v", err)
+	}
+	want := map[string]string{
+		"APP_ENV":      "staging",
+		"FROM_PROFILE": "profile",
+		"FROM_FILE":    "file",
+		"FROM_PROCESS": "process",
+		"FROM_FLAG":    "flag",
+	}
+	for key, val := range want {
+		if got := env.Get(key, ""); got != val {
+			t.Errorf("%s = %q, want %q", key, got, val)
+		}
+	}
+
+	if _, err := SetupConfig(ConfigFlags{File: "missing.env"}); err == nil {
+		t.Error("an explicit -config that does not exist was ignored")
+	}
+	if _, err := SetupConfig(ConfigFlags{Set: stringList{"NO_VALUE"}}); err == nil {
+		t.Error("-set without = accepted")
+	}
+}
+
+func TestSetupConfigProfileOverridesDotEnv(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.Mkdir("config", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"config/prod.env": "GRPC_TLS_MODE=mtls\nLOG_FORMAT=json\n",
+		".env":            "APP_ENV=prod\nGRPC_TLS_MODE=plaintext\nLOG_FORMAT=text\nDB_HOST=localhost\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	env, err := SetupConfig(ConfigFlags{})
+	if err != nil {
+		t.Fatalf("SetupConfig: %v", err)
+	}
+	// a developer .env left next to the binary must not turn off prod tls
+	want := map[string]string{"APP_ENV": "prod", "GRPC_TLS_MODE": "mtls", "LOG_FORMAT": "json", "DB_HOST": "localhost"}
+	for key, val := range want {
+		if got := env.Get(key, ""); got != val {
+			t.Errorf("%s = %q, want %q", key, got, val)
+		}
+	}
+}
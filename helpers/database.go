@@ -1,32 +1,219 @@
 package helpers
 
 import (
-	"ewallet-topup/internal/models"
+	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"time"
 
-	"github.com/sirupsen/logrus"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 )
 
-var DB *gorm.DB
+const (
+	DBDriverMySQL    = "mysql"
+	DBDriverPostgres = "postgres"
+	// DBDriverSQLite is for local runs and tests, it needs a cgo enabled build
+	DBDriverSQLite = "sqlite"
+)
+
+type DBConfig struct {
+	Driver string
+	Host   string
+	Port   string
+	// Name is the database name, or the file path (or :memory:) for sqlite
+	Name     string
+	User     string
+	Password string
+	// SSLMode is passed to postgres as sslmode, e.g. disable, require, verify-full
+	SSLMode string
+	// AutoMigrate applies pending migrations when a command starts, for local development.
+	// Otherwise the migrate command has to run first, startup refuses an outdated schema.
+	AutoMigrate bool
+	// ReplicaHosts are read replicas as host or host:port, same database and credentials.
+	// Reads without a Write clause go to a random replica, writes and transactions stay on the primary.
+	ReplicaHosts []string
+	Pool         DBPoolConfig
+}
+
+// DBPoolConfig applies to the primary and to every replica pool.
+type DBPoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+func LoadDBConfig(env *Env) DBConfig {
+	driver := env.Get("DB_DRIVER", DBDriverMySQL)
+	defaultPort := "3306"
+	if driver == DBDriverPostgres {
+		defaultPort = "5432"
+	}
+	return DBConfig{
+		Driver:       driver,
+		Host:         env.Get("DB_HOST", "127.0.0.1"),
+		Port:         env.Get("DB_PORT", defaultPort),
+		Name:         env.Get("DB_NAME", ""),
+		User:         env.Get("DB_USER", ""),
+		Password:     env.Get("DB_PASSWORD", ""),
+		SSLMode:      env.Get("DB_SSLMODE", "disable"),
+		AutoMigrate:  env.GetBool("DB_AUTO_MIGRATE", false),
+		ReplicaHosts: splitList(env.Get("DB_REPLICA_HOSTS", "")),
+		Pool: DBPoolConfig{
+			MaxOpenConns:    env.GetInt("DB_MAX_OPEN_CONNS", 25),
+			MaxIdleConns:    env.GetInt("DB_MAX_IDLE_CONNS", 10),
+			ConnMaxLifetime: env.GetDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute),
+			ConnMaxIdleTime: env.GetDuration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute),
+		},
+	}
+}
 
-func SetupMySQL() (*gorm.DB, error) {
-	var err error
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", GetEnv("DB_USER", ""), GetEnv("DB_PASSWORD", ""), GetEnv("DB_HOST", "127.0.0.1"), GetEnv("DB_PORT", "3306"), GetEnv("DB_NAME", ""))
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// replica returns the config of a replica host, the port defaults to the primary one.
+func (c DBConfig) replica(host string) DBConfig {
+	replica := c
+	replica.Host, replica.Port = host, c.Port
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		replica.Host, replica.Port = h, p
+	}
+	replica.ReplicaHosts = nil
+	return replica
+}
 
-	DB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+func (c DBConfig) DSN() string {
+	switch c.Driver {
+	case DBDriverPostgres:
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(c.User, c.Password),
+			Host:     c.Host + ":" + c.Port,
+			Path:     c.Name,
+			RawQuery: url.Values{"sslmode": {c.SSLMode}}.Encode(),
+		}
+		return dsn.String()
+	case DBDriverSQLite:
+		// wait for the write lock instead of failing with SQLITE_BUSY
+		sep := "?"
+		if strings.Contains(c.Name, "?") {
+			sep = "&"
+		}
+		return c.Name + sep + "_busy_timeout=5000&_foreign_keys=on"
+	default:
+		return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", c.User, c.Password, c.Host, c.Port, c.Name)
+	}
+}
+
+// open creates the connection pool of c and a gorm dialector on top of it, so the pool
+// stays reachable for stats and shutdown when dbresolver owns the replicas.
+func (c DBConfig) open() (*sql.DB, gorm.Dialector, error) {
+	var driverName string
+	switch c.Driver {
+	case DBDriverMySQL:
+		driverName = "mysql"
+	case DBDriverPostgres:
+		// registered by gorm.io/driver/postgres through pgx/v5/stdlib
+		driverName = "pgx"
+	case DBDriverSQLite:
+		driverName = sqlite.DriverName
+	default:
+		return nil, nil, fmt.Errorf("unknown DB_DRIVER %q", c.Driver)
+	}
+	sqlDB, err := sql.Open(driverName, c.DSN())
 	if err != nil {
-		log.Fatal("failed to connect to database: ", err)
+		return nil, nil, err
 	}
 
-	logrus.Info("Successfully connect to database..")
+	switch c.Driver {
+	case DBDriverMySQL:
+		return sqlDB, mysql.New(mysql.Config{DSN: c.DSN(), Conn: sqlDB}), nil
+	case DBDriverPostgres:
+		return sqlDB, postgres.New(postgres.Config{DSN: c.DSN(), Conn: sqlDB}), nil
+	default:
+		return sqlDB, sqlite.New(sqlite.Config{DSN: c.DSN(), Conn: sqlDB}), nil
+	}
+}
 
-	err = DB.AutoMigrate(&models.Transaction{})
+func (p DBPoolConfig) apply(sqlDB *sql.DB) {
+	sqlDB.SetMaxOpenConns(p.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(p.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(p.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(p.ConnMaxIdleTime)
+}
+
+// DBPool is one connection pool behind the gorm handle, named primary or replica_<n>.
+type DBPool struct {
+	Name string
+	DB   *sql.DB
+}
+
+// DBPools are the pools opened by SetupDB, exposed for metrics and health.
+type DBPools []DBPool
+
+// Close closes every pool, used on shutdown.
+func (p DBPools) Close() error {
+	var errs []error
+	for _, pool := range p {
+		errs = append(errs, pool.DB.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// SetupDB connects with the driver picked by DB_DRIVER and registers the read replicas.
+func SetupDB(cfg DBConfig, logger *slog.Logger) (*gorm.DB, DBPools, error) {
+	sqlDB, dialector, err := cfg.open()
+	if err != nil {
+		return nil, nil, err
+	}
+	pools := DBPools{{Name: "primary", DB: sqlDB}}
+
+	cfg.Pool.apply(sqlDB)
+	if cfg.Driver == DBDriverSQLite {
+		// sqlite allows one writer at a time and every :memory: connection is its own database
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
-		return nil, err
+		_ = pools.Close()
+		return nil, nil, err
+	}
+
+	if len(cfg.ReplicaHosts) > 0 {
+		var replicas []gorm.Dialector
+		for i, host := range cfg.ReplicaHosts {
+			replicaDB, replica, err := cfg.replica(host).open()
+			if err != nil {
+				_ = pools.Close()
+				return nil, nil, fmt.Errorf("replica %s: %w", host, err)
+			}
+			cfg.Pool.apply(replicaDB)
+			pools = append(pools, DBPool{Name: fmt.Sprintf("replica_%d", i), DB: replicaDB})
+			replicas = append(replicas, replica)
+		}
+		err = db.Use(dbresolver.Register(dbresolver.Config{Replicas: replicas, Policy: dbresolver.RandomPolicy{}}))
+		if err != nil {
+			_ = pools.Close()
+			return nil, nil, err
+		}
 	}
 
-	return DB, nil
+	logger.Info("successfully connected to database", "driver", cfg.Driver, "replicas", len(cfg.ReplicaHosts))
+	return db, pools, nil
 }
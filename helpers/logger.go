@@ -1,16 +1,169 @@
 package helpers
 
-import "github.com/sirupsen/logrus"
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
 
-var Logger *logrus.Logger
+	"go.opentelemetry.io/otel/trace"
+	tlog "go.temporal.io/sdk/log"
+)
 
-func SetupLogger() {
-	log := logrus.New()
+type logFieldsKey struct{}
 
-	log.SetFormatter(&logrus.JSONFormatter{
-		PrettyPrint: true,
-	})
+type LogConfig struct {
+	Level  slog.Level
+	Format string
+}
+
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// LoadLogConfig reads LOG_LEVEL (debug, info, warn, error) and LOG_FORMAT (json or text).
+func LoadLogConfig(env *Env) LogConfig {
+	var level slog.Level
+	raw := env.Get("LOG_LEVEL", "info")
+	if err := level.UnmarshalText([]byte(raw)); err != nil {
+		env.invalid("LOG_LEVEL: %q is not one of debug, info, warn, error", raw)
+		level = slog.LevelInfo
+	}
+	return LogConfig{
+		Level:  level,
+		Format: env.Get("LOG_FORMAT", LogFormatJSON),
+	}
+}
+
+// NewLogger builds the process logger writing to w, with redaction and the context fields.
+func NewLogger(cfg LogConfig, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.Level, ReplaceAttr: redactAttr}
+	var handler slog.Handler = slog.NewJSONHandler(w, opts)
+	if cfg.Format == LogFormatText {
+		handler = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(contextHandler{Handler: handler})
+}
+
+// TemporalLogger bridges logger into the temporal sdk, used for workflow and activity logs.
+func TemporalLogger(logger *slog.Logger) tlog.Logger {
+	return tlog.NewStructuredLogger(logger)
+}
+
+// WithLogFields returns a context whose log lines carry the given key/value pairs,
+// e.g. WithLogFields(ctx, "reference", ref). A later value replaces an earlier one with the same key.
+func WithLogFields(ctx context.Context, args ...any) context.Context {
+	current, _ := ctx.Value(logFieldsKey{}).([]slog.Attr)
+	fields := make([]slog.Attr, 0, len(current)+len(args)/2)
+
+	added := logAttrs(args)
+	for _, attr := range current {
+		if !hasAttr(added, attr.Key) {
+			fields = append(fields, attr)
+		}
+	}
+	fields = append(fields, added...)
+	return context.WithValue(ctx, logFieldsKey{}, fields)
+}
+
+// logAttrs parses key/value pairs the same way slog.Logger.With does.
+func logAttrs(args []any) []slog.Attr {
+	attrs := make([]slog.Attr, 0, len(args)/2)
+	for len(args) > 0 {
+		switch arg := args[0].(type) {
+		case slog.Attr:
+			attrs = append(attrs, arg)
+			args = args[1:]
+		case string:
+			if len(args) == 1 {
+				attrs = append(attrs, slog.String("!BADKEY", arg))
+				return attrs
+			}
+			attrs = append(attrs, slog.Any(arg, args[1]))
+			args = args[2:]
+		default:
+			attrs = append(attrs, slog.Any("!BADKEY", arg))
+			args = args[1:]
+		}
+	}
+	return attrs
+}
+
+func hasAttr(attrs []slog.Attr, key string) bool {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// contextHandler adds the trace ids and the WithLogFields values of the record context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+		}
+		if fields, ok := ctx.Value(logFieldsKey{}).([]slog.Attr); ok {
+			r.AddAttrs(fields...)
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+var sensitiveLogKeys = []string{"token", "authorization", "password", "secret", "api_key", "apikey", "private_key"}
+
+// redactAttr masks values of sensitive keys and anything that looks like a bearer token.
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() != slog.KindString {
+		return a
+	}
+	key := strings.ToLower(a.Key)
+	for _, sensitive := range sensitiveLogKeys {
+		if strings.Contains(key, sensitive) {
+			return slog.String(a.Key, RedactSecret(a.Value.String()))
+		}
+	}
+	if strings.HasPrefix(a.Value.String(), "Bearer ") {
+		return slog.String(a.Key, RedactSecret(a.Value.String()))
+	}
+	return a
+}
+
+// RedactSecret keeps the last 4 characters so a value can still be matched, e.g. "****a1b2".
+func RedactSecret(value string) string {
+	prefix := ""
+	if strings.HasPrefix(value, "Bearer ") {
+		prefix = "Bearer "
+		value = strings.TrimPrefix(value, prefix)
+	}
+	if len(value) <= 8 {
+		return prefix + "****"
+	}
+	return prefix + "****" + value[len(value)-4:]
+}
 
-	log.Info("Logger initiated using logrus")
-	Logger = log
+// LevelForStatus logs 5xx as errors, 4xx as warnings and the rest as info.
+func LevelForStatus(status int) slog.Level {
+	switch {
+	case status >= 500:
+		return slog.LevelError
+	case status >= 400:
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
 }
@@ -0,0 +1,291 @@
+package helpers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	TLSModePlaintext = "plaintext"
+	TLSModeTLS       = "tls"
+	TLSModeMTLS      = "mtls"
+)
+
+type TLSConfig struct {
+	Mode           string
+	CAFile         string
+	CertFile       string
+	KeyFile        string
+	ServerName     string
+	ReloadInterval time.Duration
+}
+
+// LoadTLSConfig reads <prefix>_TLS_* and falls back to the shared GRPC_TLS_* values,
+// e.g. LoadTLSConfig(env, "UMS") reads UMS_TLS_MODE then GRPC_TLS_MODE.
+func LoadTLSConfig(env *Env, prefix string) TLSConfig {
+	get := func(key, val string) string {
+		return env.Get(prefix+"_TLS_"+key, env.Get("GRPC_TLS_"+key, val))
+	}
+	reload, err := time.ParseDuration(get("RELOAD_INTERVAL", "1m"))
+	if err != nil {
+		env.invalid("%s_TLS_RELOAD_INTERVAL: %q is not a duration", prefix, get("RELOAD_INTERVAL", ""))
+		reload = time.Minute
+	}
+	return TLSConfig{
+		Mode:           get("MODE", TLSModePlaintext),
+		CAFile:         get("CA_FILE", ""),
+		CertFile:       get("CERT_FILE", ""),
+		KeyFile:        get("KEY_FILE", ""),
+		ServerName:     env.Get(prefix+"_TLS_SERVER_NAME", ""),
+		ReloadInterval: reload,
+	}
+}
+
+// ClientCredentials builds transport credentials for an outbound grpc connection. Failed
+// certificate reloads are logged to logger.
+func (c TLSConfig) ClientCredentials(logger *slog.Logger) (credentials.TransportCredentials, error) {
+	switch c.Mode {
+	case TLSModePlaintext, "":
+		return insecure.NewCredentials(), nil
+	case TLSModeTLS, TLSModeMTLS:
+	default:
+		return nil, fmt.Errorf("unknown tls mode %q", c.Mode)
+	}
+
+	reloader, err := newCertReloader(c, c.Mode == TLSModeMTLS, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: c.ServerName,
+	}
+	if c.Mode == TLSModeMTLS {
+		tlsCfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return reloader.certificate()
+		}
+	}
+	if c.CAFile != "" {
+		// the CA can rotate on disk, so verification is done against the current pool
+		// instead of a RootCAs snapshot taken at startup
+		tlsCfg.InsecureSkipVerify = true
+		return &poolCredentials{TransportCredentials: credentials.NewTLS(tlsCfg), cfg: tlsCfg, reloader: reloader}, nil
+	}
+	return credentials.NewTLS(tlsCfg), nil
+}
+
+// poolCredentials verifies the server against the current CA pool. The expected name is
+// ServerName or else the host of the dial target: the handshake leaves cs.ServerName empty
+// for an ip target, which would make x509 skip the name check.
+type poolCredentials struct {
+	credentials.TransportCredentials
+	cfg      *tls.Config
+	reloader *certReloader
+}
+
+func (p *poolCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	serverName := p.cfg.ServerName
+	if serverName == "" {
+		serverName = authority
+		if host, _, err := net.SplitHostPort(authority); err == nil {
+			serverName = host
+		}
+	}
+	cfg := p.cfg.Clone()
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		return p.reloader.verifyServer(cs, serverName)
+	}
+	return credentials.NewTLS(cfg).ClientHandshake(ctx, authority, rawConn)
+}
+
+func (p *poolCredentials) Clone() credentials.TransportCredentials {
+	return &poolCredentials{TransportCredentials: p.TransportCredentials.Clone(), cfg: p.cfg.Clone(), reloader: p.reloader}
+}
+
+// ServerCredentials builds transport credentials for the inbound grpc server.
+// In mtls mode client certificates are required and checked against CAFile.
+func (c TLSConfig) ServerCredentials(logger *slog.Logger) (credentials.TransportCredentials, error) {
+	switch c.Mode {
+	case TLSModePlaintext, "":
+		return insecure.NewCredentials(), nil
+	case TLSModeTLS, TLSModeMTLS:
+	default:
+		return nil, fmt.Errorf("unknown tls mode %q", c.Mode)
+	}
+
+	reloader, err := newCertReloader(c, true, logger)
+	if err != nil {
+		return nil, err
+	}
+	if c.Mode == TLSModeMTLS && c.CAFile == "" {
+		return nil, fmt.Errorf("mtls server requires a CA file")
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, err := reloader.certificate()
+			if err != nil {
+				return nil, err
+			}
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				// grpc requires h2, the returned config replaces the one credentials.NewTLS prepared
+				NextProtos: []string{"h2"},
+			}
+			if c.Mode == TLSModeMTLS {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				cfg.ClientCAs = reloader.caPool()
+			}
+			return cfg, nil
+		},
+	}
+	return credentials.NewTLS(tlsCfg), nil
+}
+
+// certReloader re-reads the certificate, key and CA files when their mtime changes,
+// checked at most once per ReloadInterval during handshakes.
+type certReloader struct {
+	cfg       TLSConfig
+	needsCert bool
+	log       *slog.Logger
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+	modTimes  map[string]time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(cfg TLSConfig, needsCert bool, logger *slog.Logger) (*certReloader, error) {
+	r := &certReloader{cfg: cfg, needsCert: needsCert, log: logger, modTimes: map[string]time.Time{}}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) load() error {
+	var (
+		cert *tls.Certificate
+		pool *x509.CertPool
+	)
+	if r.needsCert {
+		if r.cfg.CertFile == "" || r.cfg.KeyFile == "" {
+			return fmt.Errorf("tls cert and key files are required")
+		}
+		loaded, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load tls key pair: %w", err)
+		}
+		cert = &loaded
+	}
+	if r.cfg.CAFile != "" {
+		pem, err := os.ReadFile(r.cfg.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read tls ca file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in tls ca file %s", r.cfg.CAFile)
+		}
+	}
+
+	modTimes := map[string]time.Time{}
+	for _, f := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.CAFile} {
+		if f == "" {
+			continue
+		}
+		if info, err := os.Stat(f); err == nil {
+			modTimes[f] = info.ModTime()
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = cert
+	r.pool = pool
+	r.modTimes = modTimes
+	r.lastCheck = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *certReloader) maybeReload() {
+	r.mu.RLock()
+	due := time.Since(r.lastCheck) >= r.cfg.ReloadInterval
+	modTimes := r.modTimes
+	r.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	changed := false
+	for f, modTime := range modTimes {
+		if info, err := os.Stat(f); err == nil && !info.ModTime().Equal(modTime) {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		r.mu.Lock()
+		r.lastCheck = time.Now()
+		r.mu.Unlock()
+		return
+	}
+	if err := r.load(); err != nil {
+		// keep the previous certificate, a half written file should not break traffic
+		r.mu.Lock()
+		r.lastCheck = time.Now()
+		r.mu.Unlock()
+		r.log.Warn("failed to reload tls certificates", "error", err)
+	}
+}
+
+func (r *certReloader) certificate() (*tls.Certificate, error) {
+	r.maybeReload()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.cert == nil {
+		return nil, fmt.Errorf("tls certificate not loaded")
+	}
+	return r.cert, nil
+}
+
+func (r *certReloader) caPool() *x509.CertPool {
+	r.maybeReload()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+func (r *certReloader) verifyServer(cs tls.ConnectionState, serverName string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("server did not present a certificate")
+	}
+	if serverName == "" {
+		// x509 would accept a certificate for any name
+		return fmt.Errorf("no server name to verify the server certificate against")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         r.caPool(),
+		Intermediates: intermediates,
+	})
+	return err
+}
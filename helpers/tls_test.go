@@ -0,0 +1,350 @@
+package helpers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+var testSerial int64
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newTestCA(t *testing.T, name string) testCA {
+	t.Helper()
+	key := newTestKey(t)
+	testSerial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(testSerial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the cert and key pem of a leaf signed by ca, valid for localhost.
+func (ca testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+	return ca.issueFor(t, name, usage, "localhost")
+}
+
+// issueFor is issue with the given dns names and ip addresses as SANs.
+func (ca testCA) issueFor(t *testing.T, name string, usage x509.ExtKeyUsage, hosts ...string) ([]byte, []byte) {
+	t.Helper()
+	key := newTestKey(t)
+	testSerial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(testSerial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeFile writes data and moves the mtime forward, so a rewrite within the file system
+// timestamp resolution still counts as a change.
+func writeFile(t *testing.T, path string, data []byte) string {
+	t.Helper()
+	modTime := time.Now()
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime().Add(time.Second)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+type tlsFiles struct {
+	dir string
+	CA  string
+}
+
+func newTLSFiles(t *testing.T, ca testCA) tlsFiles {
+	t.Helper()
+	dir := t.TempDir()
+	return tlsFiles{dir: dir, CA: writeFile(t, filepath.Join(dir, "ca.pem"), ca.pem)}
+}
+
+// pair writes a certificate issued by ca under name and returns its TLSConfig file fields.
+func (f tlsFiles) pair(t *testing.T, ca testCA, name string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	cert, key := ca.issue(t, name, usage)
+	return writeFile(t, filepath.Join(f.dir, name+".pem"), cert), writeFile(t, filepath.Join(f.dir, name+"-key.pem"), key)
+}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// startHealthServer serves the grpc health service on an in-memory listener.
+func startHealthServer(t *testing.T, cfg TLSConfig) *bufconn.Listener {
+	t.Helper()
+	creds, err := cfg.ServerCredentials(discardLogger)
+	if err != nil {
+		t.Fatalf("ServerCredentials: %v", err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.Creds(creds))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return lis
+}
+
+func checkHealth(t *testing.T, lis *bufconn.Listener, cfg TLSConfig) error {
+	t.Helper()
+	creds, err := cfg.ClientCredentials(discardLogger)
+	if err != nil {
+		t.Fatalf("ClientCredentials: %v", err)
+	}
+	return checkHealthWith(t, lis, creds)
+}
+
+func checkHealthWith(t *testing.T, lis *bufconn.Listener, creds credentials.TransportCredentials) error {
+	t.Helper()
+	return checkHealthAt(t, lis, "bufnet", creds)
+}
+
+// checkHealthAt dials lis as if it were target, which sets the authority of the handshake.
+func checkHealthAt(t *testing.T, lis *bufconn.Listener, target string, creds credentials.TransportCredentials) error {
+	t.Helper()
+	conn, err := grpc.NewClient("passthrough:///"+target,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(creds),
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+func TestTLSPlaintext(t *testing.T) {
+	lis := startHealthServer(t, TLSConfig{Mode: TLSModePlaintext})
+	if err := checkHealth(t, lis, TLSConfig{Mode: TLSModePlaintext}); err != nil {
+		t.Fatalf("plaintext health check: %v", err)
+	}
+}
+
+func TestTLSServerVerifiedAgainstCA(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	files := newTLSFiles(t, ca)
+	cert, key := files.pair(t, ca, "server", x509.ExtKeyUsageServerAuth)
+	lis := startHealthServer(t, TLSConfig{Mode: TLSModeTLS, CertFile: cert, KeyFile: key})
+
+	client := TLSConfig{Mode: TLSModeTLS, CAFile: files.CA, ServerName: "localhost"}
+	if err := checkHealth(t, lis, client); err != nil {
+		t.Fatalf("tls health check: %v", err)
+	}
+
+	other := newTLSFiles(t, newTestCA(t, "other-ca"))
+	if err := checkHealth(t, lis, TLSConfig{Mode: TLSModeTLS, CAFile: other.CA, ServerName: "localhost"}); err == nil {
+		t.Fatal("client accepted a server certificate from an unknown CA")
+	}
+	if err := checkHealth(t, lis, TLSConfig{Mode: TLSModeTLS, CAFile: files.CA, ServerName: "ums.internal"}); err == nil {
+		t.Fatal("client accepted a server certificate for another name")
+	}
+}
+
+func TestTLSServerNameFromTarget(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	files := newTLSFiles(t, ca)
+	wrongCert, wrongKey := ca.issueFor(t, "wrong", x509.ExtKeyUsageServerAuth, "ums.internal", "10.0.0.9")
+	rightCert, rightKey := ca.issueFor(t, "right", x509.ExtKeyUsageServerAuth, "10.0.0.5")
+	wrong := startHealthServer(t, TLSConfig{Mode: TLSModeTLS,
+		CertFile: writeFile(t, filepath.Join(files.dir, "wrong.pem"), wrongCert), KeyFile: writeFile(t, filepath.Join(files.dir, "wrong-key.pem"), wrongKey)})
+	right := startHealthServer(t, TLSConfig{Mode: TLSModeTLS,
+		CertFile: writeFile(t, filepath.Join(files.dir, "right.pem"), rightCert), KeyFile: writeFile(t, filepath.Join(files.dir, "right-key.pem"), rightKey)})
+
+	// no ServerName, so the name comes from the ip target, which the handshake sends no SNI for
+	creds, err := TLSConfig{Mode: TLSModeTLS, CAFile: files.CA}.ClientCredentials(discardLogger)
+	if err != nil {
+		t.Fatalf("ClientCredentials: %v", err)
+	}
+	if err := checkHealthAt(t, wrong, "10.0.0.5:7000", creds); err == nil {
+		t.Fatal("client accepted a server certificate without the target ip")
+	}
+	if err := checkHealthAt(t, wrong, "ums.example:7000", creds); err == nil {
+		t.Fatal("client accepted a server certificate without the target host")
+	}
+	if err := checkHealthAt(t, right, "10.0.0.5:7000", creds); err != nil {
+		t.Fatalf("tls health check by ip: %v", err)
+	}
+}
+
+func TestMTLSRequiresClientCertificate(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	files := newTLSFiles(t, ca)
+	serverCert, serverKey := files.pair(t, ca, "server", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := files.pair(t, ca, "client", x509.ExtKeyUsageClientAuth)
+	lis := startHealthServer(t, TLSConfig{Mode: TLSModeMTLS, CAFile: files.CA, CertFile: serverCert, KeyFile: serverKey})
+
+	client := TLSConfig{Mode: TLSModeMTLS, CAFile: files.CA, CertFile: clientCert, KeyFile: clientKey, ServerName: "localhost"}
+	if err := checkHealth(t, lis, client); err != nil {
+		t.Fatalf("mtls health check: %v", err)
+	}
+
+	if err := checkHealth(t, lis, TLSConfig{Mode: TLSModeTLS, CAFile: files.CA, ServerName: "localhost"}); err == nil {
+		t.Fatal("mtls server accepted a client without certificate")
+	}
+
+	other := newTestCA(t, "other-ca")
+	otherFiles := newTLSFiles(t, other)
+	otherCert, otherKey := otherFiles.pair(t, other, "client", x509.ExtKeyUsageClientAuth)
+	stranger := TLSConfig{Mode: TLSModeMTLS, CAFile: files.CA, CertFile: otherCert, KeyFile: otherKey, ServerName: "localhost"}
+	if err := checkHealth(t, lis, stranger); err == nil {
+		t.Fatal("mtls server accepted a client certificate from an unknown CA")
+	}
+}
+
+func TestMTLSServerConfigErrors(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	files := newTLSFiles(t, ca)
+	cert, key := files.pair(t, ca, "server", x509.ExtKeyUsageServerAuth)
+
+	if _, err := (TLSConfig{Mode: TLSModeMTLS, CertFile: cert, KeyFile: key}).ServerCredentials(discardLogger); err == nil {
+		t.Error("mtls server without a CA file accepted")
+	}
+	if _, err := (TLSConfig{Mode: TLSModeTLS}).ServerCredentials(discardLogger); err == nil {
+		t.Error("tls server without a certificate accepted")
+	}
+	if _, err := (TLSConfig{Mode: "ssl"}).ClientCredentials(discardLogger); err == nil {
+		t.Error("unknown tls mode accepted")
+	}
+}
+
+func TestMTLSReloadsRotatedFiles(t *testing.T) {
+	oldCA := newTestCA(t, "old-ca")
+	newCA := newTestCA(t, "new-ca")
+	files := newTLSFiles(t, oldCA)
+	serverCert, serverKey := files.pair(t, oldCA, "server", x509.ExtKeyUsageServerAuth)
+	lis := startHealthServer(t, TLSConfig{Mode: TLSModeMTLS, CAFile: files.CA, CertFile: serverCert, KeyFile: serverKey})
+
+	// the client already moved to the new CA, the server still trusts the old one
+	clientFiles := newTLSFiles(t, newCA)
+	clientCert, clientKey := clientFiles.pair(t, newCA, "client", x509.ExtKeyUsageClientAuth)
+	client := TLSConfig{Mode: TLSModeMTLS, CAFile: clientFiles.CA, CertFile: clientCert, KeyFile: clientKey, ServerName: "localhost"}
+	if err := checkHealth(t, lis, client); err == nil {
+		t.Fatal("handshake succeeded before the server rotated")
+	}
+
+	// rotate the server onto the new CA in place, without a restart
+	writeFile(t, files.CA, newCA.pem)
+	cert, key := newCA.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	writeFile(t, serverCert, cert)
+	writeFile(t, serverKey, key)
+
+	if err := checkHealth(t, lis, client); err != nil {
+		t.Fatalf("health check after rotation: %v", err)
+	}
+}
+
+func TestCertReloaderKeepsCertificateOnBrokenRewrite(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	files := newTLSFiles(t, ca)
+	certFile, keyFile := files.pair(t, ca, "server", x509.ExtKeyUsageServerAuth)
+	r, err := newCertReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile}, true, discardLogger)
+	if err != nil {
+		t.Fatalf("newCertReloader: %v", err)
+	}
+	before, err := r.certificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a half written certificate keeps the previous one in use
+	writeFile(t, certFile, []byte("-----BEGIN CERTIFICATE-----\n"))
+	after, err := r.certificate()
+	if err != nil {
+		t.Fatalf("certificate after broken rewrite: %v", err)
+	}
+	if after != before {
+		t.Error("certificate replaced by a broken file")
+	}
+}
+
+func TestCertReloaderWaitsForReloadInterval(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	files := newTLSFiles(t, ca)
+	certFile, keyFile := files.pair(t, ca, "server", x509.ExtKeyUsageServerAuth)
+	r, err := newCertReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile, ReloadInterval: time.Hour}, true, discardLogger)
+	if err != nil {
+		t.Fatalf("newCertReloader: %v", err)
+	}
+	before, _ := r.certificate()
+
+	cert, key := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, cert)
+	writeFile(t, keyFile, key)
+	if after, _ := r.certificate(); after != before {
+		t.Error("certificate reloaded before the reload interval passed")
+	}
+}
@@ -18,5 +18,27 @@ func (api *Healthcheck) HealthcheckHandlerHTTP(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, nil)
 		return
 	}
-	helpers.SendResponseHTTP(c, http.StatusOK, msg, nil)
+	helpers.SendResponseHTTP(c, http.StatusOK, msg, gin.H{
+		"circuit_breakers": api.HealthcheckServices.CircuitBreakers(),
+	})
+}
+
+func (api *Healthcheck) LivenessHandlerHTTP(c *gin.Context) {
+	helpers.SendResponseHTTP(c, http.StatusOK, "alive", nil)
+}
+
+func (api *Healthcheck) ReadinessHandlerHTTP(c *gin.Context) {
+	report := api.HealthcheckServices.Readiness(c.Request.Context())
+
+	code := http.StatusOK
+	if !report.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	helpers.SendResponseHTTP(c, code, report.Status, gin.H{
+		"ready":            report.Ready,
+		"components":       report.Components,
+		"db_pools":         report.DBPools,
+		"checked_at":       report.CheckedAt,
+		"circuit_breakers": api.HealthcheckServices.CircuitBreakers(),
+	})
 }
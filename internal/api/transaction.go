@@ -3,67 +3,89 @@ package api
 import (
 	"context"
 	constants "ewallet-topup/constant"
+	"ewallet-topup/external"
 	"ewallet-topup/helpers"
 	"ewallet-topup/internal/interfaces"
 	"ewallet-topup/internal/models"
 	"ewallet-topup/internal/workflow"
 	"ewallet-topup/internal/workflow/transaction"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go.temporal.io/api/serviceerror"
+	workflowpb "go.temporal.io/api/workflow/v1"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
 )
 
 type TransactionAPI struct {
 	TransactionService interfaces.ITransactionService
 	Temporal           client.Client
+	TaskQueues         workflow.TaskQueues
+	Logger             *slog.Logger
 }
 
 func (api *TransactionAPI) CreateTransaction(c *gin.Context) {
 	var (
-		log = helpers.Logger
+		log = api.Logger
+		ctx = c.Request.Context()
 		req models.CreateTransactionRequest
 	)
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		log.Error("failed to parse request: ", err)
+		log.ErrorContext(ctx, "failed to parse request", "error", err)
 		helpers.SendResponseHTTP(c, http.StatusBadRequest, constants.ErrFailedBadRequest, nil)
 		return
 	}
-	authHeader := c.GetHeader("Authorization")
-	const prefix = "Bearer "
-	if strings.HasPrefix(authHeader, prefix) {
-		req.Token = authHeader[len(prefix):]
-	} else {
-		req.Token = authHeader
-	}
-
-	token, ok := c.Get("token")
+	tokenData, ok := api.tokenData(c)
 	if !ok {
-		log.Error("failed to get token data")
-		helpers.SendResponseHTTP(c, http.StatusInternalServerError, constants.ErrServerError, nil)
 		return
 	}
+	req.UserID = tokenData.UserID
+	req.User = models.TokenData{
+		UserID:   tokenData.UserID,
+		Username: tokenData.Username,
+		FullName: tokenData.FullName,
+		Email:    tokenData.Email,
+	}
 
-	req.UserID = token.(models.TokenData).UserID
-
-	//req.Token = c.GetHeader("Authorization")
+	if req.Type == string(models.TransactionTypePurchase) {
+		err := api.TransactionService.CheckSufficientBalance(ctx, tokenData.Token, float64(req.Amount))
+		if errors.Is(err, external.ErrInsufficientBalance) {
+			log.WarnContext(ctx, "purchase rejected", "error", err)
+			helpers.SendResponseHTTP(c, http.StatusUnprocessableEntity, constants.ErrInsufficientBal, gin.H{
+				"code": constants.ErrCodeInsufficientBalance,
+			})
+			return
+		}
+		if err != nil {
+			log.ErrorContext(ctx, "failed to check balance", "error", err)
+			helpers.SendResponseHTTP(c, http.StatusInternalServerError, constants.ErrServerError, nil)
+			return
+		}
+	}
 
 	req.Referance = helpers.GenerateReference()
 	workflowOptions := client.StartWorkflowOptions{
-		ID:        "trx_" + req.Referance,
-		TaskQueue: workflow.TransactionTaskQueue,
+		ID:        workflow.TransactionWorkflowID(req.Referance),
+		TaskQueue: api.TaskQueues.For(models.TransactionType(req.Type)),
 	}
+	ctx = helpers.WithLogFields(ctx, "reference", req.Referance, "workflow_id", workflowOptions.ID)
+	c.Request = c.Request.WithContext(ctx)
 
 	we, err := api.Temporal.ExecuteWorkflow(
-		context.Background(),
+		ctx,
 		workflowOptions,
 		transaction.TransactionWorkflow,
 		req,
 	)
 	if err != nil {
-		log.Error(err)
+		log.ErrorContext(ctx, "failed to start transaction workflow", "error", err)
 		helpers.SendResponseHTTP(c, http.StatusInternalServerError, constants.ErrServerError, nil)
 		return
 	}
@@ -76,47 +98,179 @@ func (api *TransactionAPI) CreateTransaction(c *gin.Context) {
 	})
 }
 
+// updateStatusTimeout is how long UpdateStatusTransaction waits for the workflow to apply a
+// confirm or cancel, a slow wallet gets 202 and the outcome is left to GetTransactionState.
+const updateStatusTimeout = 10 * time.Second
+
+// IdempotencyKeyHeader optionally names one confirm or cancel attempt of the caller. Requests
+// repeating the key get the outcome of the first one instead of a new update.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const maxIdempotencyKeyLength = 64
+
 func (api *TransactionAPI) UpdateStatusTransaction(c *gin.Context) {
 	ref := c.Param("reference")
+	workflowID := workflow.TransactionWorkflowID(ref)
+	ctx := helpers.WithLogFields(c.Request.Context(), "reference", ref, "workflow_id", workflowID)
+	c.Request = c.Request.WithContext(ctx)
 
 	var req models.UpdateTransactionStatus
 	if err := c.ShouldBindJSON(&req); err != nil {
 		helpers.SendResponseHTTP(c, http.StatusBadRequest, constants.ErrFailedBadRequest, nil)
 		return
 	}
+	tokenData, ok := api.tokenData(c)
+	if !ok {
+		return
+	}
 
-	var transactionSignalMap = map[string]string{
-		"CONFIRM": transaction.SignalTransactionConfirm,
-		"CANCEL":  transaction.SignalTransactionCancel,
+	var transactionUpdateMap = map[string]string{
+		"CONFIRM": transaction.UpdateTransactionConfirm,
+		"CANCEL":  transaction.UpdateTransactionCancel,
 	}
 
-	signal, ok := transactionSignalMap[req.Status]
+	update, ok := transactionUpdateMap[req.Status]
 	if !ok {
 		helpers.SendResponseHTTP(c, http.StatusBadRequest, constants.ErrFailedBadRequest, nil)
 		return
 	}
-	payload := transaction.SignalTransaction{
-		Reason: req.Reason,
+	idempotencyKey := c.GetHeader(IdempotencyKeyHeader)
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		helpers.SendResponseHTTP(c, http.StatusBadRequest, constants.ErrFailedBadRequest, nil)
+		return
 	}
-	err := api.Temporal.SignalWorkflow(
-		c.Request.Context(),
-		"trx_"+ref,
-		"",
-		signal,
-		payload,
-	)
+
+	waitCtx, cancel := context.WithTimeout(ctx, updateStatusTimeout)
+	defer cancel()
+	var state transaction.TransactionState
+	handle, err := api.Temporal.UpdateWorkflow(waitCtx, client.UpdateWorkflowOptions{
+		UpdateID:     updateID(tokenData.UserID, req.Status, idempotencyKey),
+		WorkflowID:   workflowID,
+		UpdateName:   update,
+		Args:         []interface{}{transaction.UpdateTransaction{UserID: tokenData.UserID, Reason: req.Reason}},
+		WaitForStage: client.WorkflowUpdateStageCompleted,
+	})
+	if err == nil {
+		err = handle.Get(waitCtx, &state)
+	}
+
+	var appErr *temporal.ApplicationError
+	var notFound *serviceerror.NotFound
+	var updateTimeout *client.WorkflowUpdateServiceTimeoutOrCanceledError
+	switch {
+	case err == nil:
+		helpers.SendResponseHTTP(c, http.StatusOK, constants.SuccessMessage, transactionStateResponse(state, nil))
+	case errors.As(err, &notFound), errors.As(err, &appErr) && appErr.Type() == transaction.ErrTypeTransactionNotFound:
+		helpers.SendResponseHTTP(c, http.StatusNotFound, constants.ErrNotFound, nil)
+	case errors.As(err, &appErr) && appErr.Type() == transaction.ErrTypeTransactionNotPending:
+		helpers.SendResponseHTTP(c, http.StatusConflict, constants.ErrNotPending, gin.H{
+			"code": constants.ErrCodeNotPending,
+		})
+	case errors.As(err, &updateTimeout) && ctx.Err() == nil:
+		api.Logger.WarnContext(ctx, "transaction update still running", "update", update)
+		helpers.SendResponseHTTP(c, http.StatusAccepted, constants.SuccessMessage, gin.H{
+			"reference": ref,
+			"status":    "PROCESSING",
+		})
+	default:
+		api.Logger.ErrorContext(ctx, "failed to update transaction workflow", "update", update, "error", err)
+		helpers.SendResponseHTTP(c, http.StatusInternalServerError, constants.ErrServerError, nil)
+	}
+}
+
+// updateID dedupes retries of one decision by the same user. Temporal answers a known update id
+// with the stored outcome without running the validator, so the id must never be shared
+// between users or another user could read the outcome of the owner's update.
+func updateID(userID int64, decision, idempotencyKey string) string {
+	id := fmt.Sprintf("%s-%d", strings.ToLower(decision), userID)
+	if idempotencyKey != "" {
+		id += "-" + idempotencyKey
+	}
+	return id
+}
+
+// GetTransactionState returns the live state of the transaction workflow, including the
+// attempts of activities that are still retrying.
+func (api *TransactionAPI) GetTransactionState(c *gin.Context) {
+	ref := c.Param("reference")
+	workflowID := workflow.TransactionWorkflowID(ref)
+	ctx := helpers.WithLogFields(c.Request.Context(), "reference", ref, "workflow_id", workflowID)
+	c.Request = c.Request.WithContext(ctx)
+
+	tokenData, ok := api.tokenData(c)
+	if !ok {
+		return
+	}
+
+	var state transaction.TransactionState
+	resp, err := api.Temporal.QueryWorkflow(ctx, workflowID, "", transaction.QueryTransactionState)
+	if err == nil {
+		err = resp.Get(&state)
+	}
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) || (err == nil && state.UserID != tokenData.UserID) {
+		helpers.SendResponseHTTP(c, http.StatusNotFound, constants.ErrNotFound, nil)
+		return
+	}
+	if err != nil {
+		api.Logger.ErrorContext(ctx, "failed to query transaction workflow", "error", err)
+		helpers.SendResponseHTTP(c, http.StatusInternalServerError, constants.ErrServerError, nil)
+		return
+	}
+
+	desc, err := api.Temporal.DescribeWorkflowExecution(ctx, workflowID, "")
 	if err != nil {
+		api.Logger.ErrorContext(ctx, "failed to describe transaction workflow", "error", err)
 		helpers.SendResponseHTTP(c, http.StatusInternalServerError, constants.ErrServerError, nil)
 		return
 	}
 
-	helpers.SendResponseHTTP(c, http.StatusOK, constants.SuccessMessage, nil)
+	helpers.SendResponseHTTP(c, http.StatusOK, constants.SuccessMessage, transactionStateResponse(state, desc.GetPendingActivities()))
+}
+
+func (api *TransactionAPI) tokenData(c *gin.Context) (models.TokenData, bool) {
+	token, ok := c.Get("token")
+	if !ok {
+		api.Logger.ErrorContext(c.Request.Context(), "failed to get token data")
+		helpers.SendResponseHTTP(c, http.StatusInternalServerError, constants.ErrServerError, nil)
+		return models.TokenData{}, false
+	}
+	return token.(models.TokenData), true
+}
+
+func transactionStateResponse(state transaction.TransactionState, pending []*workflowpb.PendingActivityInfo) models.TransactionStateResponse {
+	resp := models.TransactionStateResponse{
+		Reference:         state.Reference,
+		Type:              state.Type,
+		Status:            state.Status,
+		Step:              state.Step,
+		Reason:            state.Reason,
+		Steps:             make([]models.TransactionStep, 0, len(state.Steps)),
+		LastError:         state.LastError,
+		Deadline:          state.Deadline,
+		PendingActivities: make([]models.PendingActivity, 0, len(pending)),
+	}
+	for _, s := range state.Steps {
+		resp.Steps = append(resp.Steps, models.TransactionStep{Step: s.Step, At: s.At})
+	}
+	for _, a := range pending {
+		activity := models.PendingActivity{
+			Activity: a.GetActivityType().GetName(),
+			State:    a.GetState().String(),
+			Attempt:  a.GetAttempt(),
+		}
+		if msg := a.GetLastFailure().GetMessage(); msg != "" {
+			activity.LastError = &msg
+		}
+		resp.PendingActivities = append(resp.PendingActivities, activity)
+	}
+	return resp
 }
 
 //
 //func (api *TransactionAPI) GetTransaction(c *gin.Context) {
 //	var (
-//		log = helpers.Logger
+//		log = api.Logger
 //	)
 //
 //	token, ok := c.Get("token")
@@ -145,7 +299,7 @@ func (api *TransactionAPI) UpdateStatusTransaction(c *gin.Context) {
 //
 //func (api *TransactionAPI) GetTransactionDetail(c *gin.Context) {
 //	var (
-//		log = helpers.Logger
+//		log = api.Logger
 //	)
 //
 //	reference := c.Param("reference")
@@ -0,0 +1,215 @@
+package api_test
+
+import (
+	"encoding/json"
+	"ewallet-topup/external"
+	"ewallet-topup/internal/api"
+	"ewallet-topup/internal/fakes"
+	"ewallet-topup/internal/models"
+	"ewallet-topup/internal/workflow"
+	"ewallet-topup/internal/workflow/transaction"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+)
+
+var testUser = models.TokenData{UserID: 7, Username: "budi", FullName: "Budi", Email: "budi@example.com", Token: "token-7"}
+
+type apiTest struct {
+	temporal *fakes.Temporal
+	service  *fakes.TransactionService
+	router   *gin.Engine
+}
+
+// newAPITest routes the transaction handlers like cmd does, with the token middleware
+// replaced by one that always sets testUser.
+func newAPITest() *apiTest {
+	gin.SetMode(gin.TestMode)
+	a := &apiTest{temporal: fakes.NewTemporal(), service: fakes.NewTransactionService()}
+	handler := &api.TransactionAPI{
+		TransactionService: a.service,
+		Temporal:           a.temporal,
+		TaskQueues: workflow.TaskQueues{
+			Default: workflow.TaskQueue{Name: "transaction"},
+			ByType:  map[models.TransactionType]workflow.TaskQueue{models.TransactionTypePurchase: {Name: "transaction-purchase"}},
+		},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	a.router = gin.New()
+	group := a.router.Group("/transaction/v1", func(c *gin.Context) { c.Set("token", testUser) })
+	group.POST("/create", handler.CreateTransaction)
+	group.PUT("/update-status/:reference", handler.UpdateStatusTransaction)
+	group.GET("/:reference/state", handler.GetTransactionState)
+	return a
+}
+
+// do sends the request and decodes the data field of the response into data when set.
+func (a *apiTest) do(t *testing.T, method, path, body string, data interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	a.router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if data != nil {
+		resp := struct {
+			Data interface{} `json:"data"`
+		}{Data: data}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode %s: %v", rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestCreateTransaction(t *testing.T) {
+	a := newAPITest()
+
+	var data map[string]string
+	code := a.do(t, http.MethodPost, "/transaction/v1/create", `{"amount":150,"transaction_type":"PURCHASE","description":"pulsa","user_id":99}`, &data)
+	if code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", code)
+	}
+	started := a.temporal.Started()
+	if len(started) != 1 {
+		t.Fatalf("started %d workflows, want 1", len(started))
+	}
+	opts := started[0].Options
+	if opts.ID != workflow.TransactionWorkflowID(data["reference"]) || opts.TaskQueue != "transaction-purchase" {
+		t.Errorf("started %s on %s for reference %s", opts.ID, opts.TaskQueue, data["reference"])
+	}
+	req := started[0].Args[0].(models.CreateTransactionRequest)
+	if req.UserID != testUser.UserID || req.User.Email != testUser.Email {
+		t.Errorf("request user = %d %q, want the token user over the body", req.UserID, req.User.Email)
+	}
+	if calls := a.service.Calls(); len(calls) != 1 || calls[0].Method != "CheckSufficientBalance" {
+		t.Errorf("service calls = %v, want the balance check of a purchase", calls)
+	}
+}
+
+func TestCreateTransactionRejects(t *testing.T) {
+	a := newAPITest()
+	if code := a.do(t, http.MethodPost, "/transaction/v1/create", `{"amount":`, nil); code != http.StatusBadRequest {
+		t.Errorf("broken body = %d, want 400", code)
+	}
+
+	a.service.Errors["CheckSufficientBalance"] = errors.Wrap(external.ErrInsufficientBalance, "check")
+	var data map[string]string
+	code := a.do(t, http.MethodPost, "/transaction/v1/create", `{"amount":150,"transaction_type":"PURCHASE","description":"pulsa"}`, &data)
+	if code != http.StatusUnprocessableEntity || data["code"] != "INSUFFICIENT_BALANCE" {
+		t.Errorf("insufficient balance = %d %v, want 422", code, data)
+	}
+
+	a.service.Errors["CheckSufficientBalance"] = nil
+	a.temporal.Err = errors.New("temporal down")
+	if code := a.do(t, http.MethodPost, "/transaction/v1/create", `{"amount":150,"transaction_type":"TOPUP","description":"isi"}`, nil); code != http.StatusInternalServerError {
+		t.Errorf("temporal down = %d, want 500", code)
+	}
+	if n := len(a.temporal.Started()); n != 0 {
+		t.Errorf("started %d workflows", n)
+	}
+}
+
+func TestUpdateStatusTransaction(t *testing.T) {
+	id := workflow.TransactionWorkflowID("ref-1")
+	tests := []struct {
+		name   string
+		body   string
+		result interface{}
+		want   int
+	}{
+		{"confirmed", `{"reference":"ref-1","status":"CONFIRM"}`, transaction.TransactionState{Reference: "ref-1", Status: models.TransactionStatusSuccess}, http.StatusOK},
+		{"unknown decision", `{"reference":"ref-1","status":"MAYBE"}`, transaction.TransactionState{}, http.StatusBadRequest},
+		{"no workflow", `{"reference":"ref-1","status":"CONFIRM"}`, nil, http.StatusNotFound},
+		{"other user", `{"reference":"ref-1","status":"CANCEL"}`, temporal.NewNonRetryableApplicationError("not found", transaction.ErrTypeTransactionNotFound, nil), http.StatusNotFound},
+		{"not pending", `{"reference":"ref-1","status":"CANCEL"}`, temporal.NewNonRetryableApplicationError("done", transaction.ErrTypeTransactionNotPending, nil), http.StatusConflict},
+		{"temporal error", `{"reference":"ref-1","status":"CONFIRM"}`, errors.New("boom"), http.StatusInternalServerError},
+		{"still running", `{"reference":"ref-1","status":"CONFIRM"}`, client.NewWorkflowUpdateServiceTimeoutOrCanceledError(errors.New("deadline")), http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAPITest()
+			// the sdk reports a slow update from the handle, not from UpdateWorkflow
+			var timeout *client.WorkflowUpdateServiceTimeoutOrCanceledError
+			if err, ok := tt.result.(error); ok && errors.As(err, &timeout) {
+				a.temporal.UpdateErrors[id] = err
+				tt.result = transaction.TransactionState{}
+			}
+			if tt.result != nil {
+				a.temporal.Results[id] = tt.result
+			}
+			if code := a.do(t, http.MethodPut, "/transaction/v1/update-status/ref-1", tt.body, nil); code != tt.want {
+				t.Errorf("status = %d, want %d", code, tt.want)
+			}
+		})
+	}
+
+	a := newAPITest()
+	a.temporal.Results[id] = transaction.TransactionState{Reference: "ref-1"}
+	reason := "salah nominal"
+	a.do(t, http.MethodPut, "/transaction/v1/update-status/ref-1", `{"reference":"ref-1","status":"CANCEL","reason":"salah nominal"}`, nil)
+	updates := a.temporal.Updates()
+	if len(updates) != 1 || updates[0].WorkflowID != id || updates[0].Name != transaction.UpdateTransactionCancel {
+		t.Fatalf("updates = %+v, want one cancel of %s", updates, id)
+	}
+	arg := updates[0].Args[0].(transaction.UpdateTransaction)
+	if arg.UserID != testUser.UserID || arg.Reason == nil || *arg.Reason != reason {
+		t.Errorf("update arg = %+v, want the token user and the reason", arg)
+	}
+}
+
+func TestUpdateStatusTransactionUpdateID(t *testing.T) {
+	a := newAPITest()
+	a.temporal.Results[workflow.TransactionWorkflowID("ref-1")] = transaction.TransactionState{Reference: "ref-1"}
+	send := func(status, key string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/transaction/v1/update-status/ref-1", strings.NewReader(`{"reference":"ref-1","status":"`+status+`"}`))
+		if key != "" {
+			req.Header.Set(api.IdempotencyKeyHeader, key)
+		}
+		a.router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	send("CONFIRM", "")
+	send("CONFIRM", "")
+	send("CANCEL", "attempt-2")
+	if code := send("CONFIRM", strings.Repeat("k", 65)); code != http.StatusBadRequest {
+		t.Errorf("oversized idempotency key = %d, want 400", code)
+	}
+
+	var ids []string
+	for _, u := range a.temporal.Updates() {
+		ids = append(ids, u.UpdateID)
+	}
+	// the user id keeps another user from getting the stored outcome of these updates
+	want := "confirm-7,confirm-7,cancel-7-attempt-2"
+	if got := strings.Join(ids, ","); got != want {
+		t.Errorf("update ids = %s, want %s", got, want)
+	}
+}
+
+func TestGetTransactionState(t *testing.T) {
+	a := newAPITest()
+	a.temporal.Results[workflow.TransactionWorkflowID("ref-mine")] = transaction.TransactionState{Reference: "ref-mine", UserID: testUser.UserID, Step: "AWAITING_CONFIRMATION"}
+	a.temporal.Results[workflow.TransactionWorkflowID("ref-theirs")] = transaction.TransactionState{Reference: "ref-theirs", UserID: 8}
+
+	var state models.TransactionStateResponse
+	if code := a.do(t, http.MethodGet, "/transaction/v1/ref-mine/state", "", &state); code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	if state.Reference != "ref-mine" || state.Step != "AWAITING_CONFIRMATION" {
+		t.Errorf("state = %+v", state)
+	}
+	if code := a.do(t, http.MethodGet, "/transaction/v1/ref-theirs/state", "", nil); code != http.StatusNotFound {
+		t.Errorf("someone else's transaction = %d, want 404", code)
+	}
+	if code := a.do(t, http.MethodGet, "/transaction/v1/ref-none/state", "", nil); code != http.StatusNotFound {
+		t.Errorf("missing workflow = %d, want 404", code)
+	}
+}
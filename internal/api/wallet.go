@@ -0,0 +1,55 @@
+package api
+
+import (
+	constants "ewallet-topup/constant"
+	"ewallet-topup/external"
+	"ewallet-topup/helpers"
+	"ewallet-topup/internal/interfaces"
+	"ewallet-topup/internal/models"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+type WalletAPI struct {
+	WalletService interfaces.IWalletService
+	Logger        *slog.Logger
+}
+
+func (api *WalletAPI) GetBalance(c *gin.Context) {
+	var (
+		log = api.Logger
+		ctx = c.Request.Context()
+	)
+
+	token, ok := c.Get("token")
+	if !ok {
+		log.ErrorContext(ctx, "failed to get token data")
+		helpers.SendResponseHTTP(c, http.StatusInternalServerError, constants.ErrServerError, nil)
+		return
+	}
+
+	tokenData, ok := token.(models.TokenData)
+	if !ok {
+		log.ErrorContext(ctx, "failed to parse token data")
+		helpers.SendResponseHTTP(c, http.StatusInternalServerError, constants.ErrServerError, nil)
+		return
+	}
+
+	balance, err := api.WalletService.GetBalance(ctx, tokenData.Token)
+	if errors.Is(err, external.ErrUnauthorized) {
+		helpers.SendResponseHTTP(c, http.StatusUnauthorized, constants.ErrUnauthorized, nil)
+		return
+	}
+	if err != nil {
+		log.ErrorContext(ctx, "failed to get wallet balance", "error", err)
+		helpers.SendResponseHTTP(c, http.StatusInternalServerError, constants.ErrServerError, nil)
+		return
+	}
+
+	helpers.SendResponseHTTP(c, http.StatusOK, constants.SuccessMessage, gin.H{
+		"balance": balance,
+	})
+}
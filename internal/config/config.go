@@ -0,0 +1,369 @@
+package config
+
+import (
+	"errors"
+	"ewallet-topup/external"
+	"ewallet-topup/helpers"
+	"ewallet-topup/internal/lifecycle"
+	"ewallet-topup/internal/services"
+	"ewallet-topup/internal/tracing"
+	"ewallet-topup/internal/workflow"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ProfileLocal   = "local"
+	ProfileStaging = "staging"
+	ProfileProd    = "prod"
+
+	AuthModeUMS = "ums"
+	AuthModeJWT = "jwt"
+)
+
+// Config is everything the api and the worker read at startup. It is built once by Load
+// and handed to the components, nothing reads the env values after that.
+type Config struct {
+	App        AppConfig
+	Log        helpers.LogConfig
+	DB         helpers.DBConfig
+	Temporal   TemporalConfig
+	Auth       AuthConfig
+	External   external.Config
+	Encryption workflow.EncryptionConfig
+	Tracing    tracing.Config
+	Health     services.HealthConfig
+	GRPCServer GRPCServerConfig
+	Shutdown   lifecycle.Config
+	Sandbox    SandboxConfig
+}
+
+type AppConfig struct {
+	Name              string
+	Profile           string
+	Port              string
+	WorkerMetricsPort string
+}
+
+type TemporalConfig struct {
+	Host               string
+	Namespace          string
+	CodecServerEnabled bool
+	UIOrigin           string
+	TaskQueues         workflow.TaskQueues
+	// StickyCacheSize is the number of workflows a worker process keeps in memory between
+	// workflow tasks, shared by every task queue worker of the process
+	StickyCacheSize int
+}
+
+type AuthConfig struct {
+	// Mode is ums (validate every token through UMS) or jwt (verify locally against JWKS)
+	Mode string
+}
+
+type GRPCServerConfig struct {
+	Port string
+	TLS  helpers.TLSConfig
+}
+
+// SandboxConfig seeds the in-process fakes of the sandbox command.
+type SandboxConfig struct {
+	// Users is SANDBOX_USERS, comma separated token:user_id:balance entries
+	Users string
+}
+
+type SandboxUser struct {
+	Token   string
+	UserID  int64
+	Balance float64
+}
+
+func (s SandboxConfig) ParseUsers() ([]SandboxUser, error) {
+	var users []SandboxUser
+	for _, entry := range strings.Split(s.Users, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("%q is not token:user_id:balance", entry)
+		}
+		userID, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || userID <= 0 {
+			return nil, fmt.Errorf("%q: user_id must be a positive number", entry)
+		}
+		balance, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || balance < 0 {
+			return nil, fmt.Errorf("%q: balance must be a non negative number", entry)
+		}
+		users = append(users, SandboxUser{Token: parts[0], UserID: userID, Balance: balance})
+	}
+	return users, nil
+}
+
+// Load builds and validates the config. process names the command, e.g. "api" or "worker", and
+// only picks the default tracing service name. flags are described in helpers.SetupConfig.
+func Load(process string, flags helpers.ConfigFlags) (*Config, error) {
+	env, err := helpers.SetupConfig(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	appName := env.Get("APP_NAME", "ewallet-topup")
+	cfg := &Config{
+		App: AppConfig{
+			Name:              appName,
+			Profile:           env.Get("APP_ENV", ProfileLocal),
+			Port:              env.Get("APP_PORT", "8080"),
+			WorkerMetricsPort: env.Get("WORKER_METRICS_PORT", "9091"),
+		},
+		Log: helpers.LoadLogConfig(env),
+		DB:  helpers.LoadDBConfig(env),
+		Temporal: TemporalConfig{
+			Host:               env.Get("TEMPORAL_HOST", "localhost:7233"),
+			Namespace:          env.Get("TEMPORAL_NAMESPACE", "default"),
+			CodecServerEnabled: env.GetBool("CODEC_SERVER_ENABLED", false),
+			UIOrigin:           env.Get("TEMPORAL_UI_ORIGIN", "http://localhost:8233"),
+			TaskQueues:         workflow.LoadTaskQueues(env),
+			StickyCacheSize:    env.GetInt("TEMPORAL_STICKY_CACHE_SIZE", 10000),
+		},
+		Auth:       AuthConfig{Mode: env.Get("AUTH_MODE", AuthModeUMS)},
+		External:   external.LoadConfig(env),
+		Encryption: workflow.LoadEncryptionConfig(env),
+		Tracing:    tracing.LoadConfig(env, appName+"-"+process),
+		Health:     services.LoadHealthConfig(env),
+		GRPCServer: GRPCServerConfig{
+			Port: env.Get("GRPC_PORT", "7000"),
+			TLS:  helpers.LoadTLSConfig(env, "GRPC_SERVER"),
+		},
+		Shutdown: lifecycle.Config{
+			ShutdownTimeout: env.GetDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+			DrainDelay:      env.GetDuration("SHUTDOWN_DRAIN_DELAY", 5*time.Second),
+		},
+		Sandbox: SandboxConfig{
+			Users: env.Get("SANDBOX_USERS", "sandbox-rich:1:10000000,sandbox-broke:2:0"),
+		},
+	}
+
+	cfg.Health.UMSCritical = cfg.Auth.Mode == AuthModeUMS
+
+	// values that did not parse come first, they explain defaults Validate may complain about
+	if err := errors.Join(append(env.Errors(), cfg.Validate())...); err != nil {
+		return nil, fmt.Errorf("invalid config (profile %s):\n%w", cfg.App.Profile, err)
+	}
+	return cfg, nil
+}
+
+// Validate reports every problem at once, one line per setting, so a broken deployment
+// can be fixed in a single round.
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	switch c.App.Profile {
+	case ProfileLocal, ProfileStaging, ProfileProd:
+	default:
+		add("APP_ENV: unknown profile %q, use local, staging or prod", c.App.Profile)
+	}
+	ports := []struct{ key, value string }{
+		{"APP_PORT", c.App.Port},
+		{"WORKER_METRICS_PORT", c.App.WorkerMetricsPort},
+		{"GRPC_PORT", c.GRPCServer.Port},
+	}
+	if c.DB.Driver != helpers.DBDriverSQLite {
+		ports = append(ports, struct{ key, value string }{"DB_PORT", c.DB.Port})
+	}
+	for _, port := range ports {
+		if n, err := strconv.Atoi(port.value); err != nil || n <= 0 || n > 65535 {
+			add("%s: %q is not a valid port", port.key, port.value)
+		}
+	}
+	if c.Log.Format != helpers.LogFormatJSON && c.Log.Format != helpers.LogFormatText {
+		add("LOG_FORMAT: %q is not json or text", c.Log.Format)
+	}
+
+	if c.DB.Name == "" {
+		add("DB_NAME is required")
+	}
+	switch c.DB.Driver {
+	case helpers.DBDriverMySQL, helpers.DBDriverPostgres:
+		if c.DB.User == "" {
+			add("DB_USER is required for DB_DRIVER=%s", c.DB.Driver)
+		}
+	case helpers.DBDriverSQLite:
+		if len(c.DB.ReplicaHosts) > 0 {
+			add("DB_REPLICA_HOSTS is not supported with DB_DRIVER=sqlite")
+		}
+	default:
+		add("DB_DRIVER: %q is not mysql, postgres or sqlite", c.DB.Driver)
+	}
+	if c.DB.Pool.MaxOpenConns <= 0 {
+		add("DB_MAX_OPEN_CONNS: must be positive")
+	}
+	if c.DB.Pool.MaxIdleConns < 0 || c.DB.Pool.MaxIdleConns > c.DB.Pool.MaxOpenConns {
+		add("DB_MAX_IDLE_CONNS: must be between 0 and DB_MAX_OPEN_CONNS")
+	}
+	if c.DB.Pool.ConnMaxLifetime < 0 || c.DB.Pool.ConnMaxIdleTime < 0 {
+		add("DB_CONN_MAX_LIFETIME and DB_CONN_MAX_IDLE_TIME: must not be negative, 0 keeps connections forever")
+	}
+	if c.Temporal.Host == "" {
+		add("TEMPORAL_HOST is required")
+	}
+	for _, q := range c.Temporal.TaskQueues.All() {
+		validateWorker(q, add)
+	}
+	if c.Temporal.StickyCacheSize < 0 {
+		add("TEMPORAL_STICKY_CACHE_SIZE: must not be negative")
+	}
+
+	switch c.Auth.Mode {
+	case AuthModeUMS:
+		if c.External.UMS.Host == "" {
+			add("UMS_GRPC_HOST is required when AUTH_MODE=ums")
+		}
+	case AuthModeJWT:
+		if c.External.JWT.JWKSURL == "" && c.External.JWT.JWKSFile == "" {
+			add("JWT_JWKS_URL or JWT_JWKS_FILE is required when AUTH_MODE=jwt")
+		}
+		if c.External.JWT.Audience == "" {
+			add("JWT_AUDIENCE is required when AUTH_MODE=jwt")
+		}
+	default:
+		add("AUTH_MODE: %q is not ums or jwt", c.Auth.Mode)
+	}
+
+	if u, err := url.Parse(c.External.Wallet.Host); err != nil || u.Scheme == "" || u.Host == "" {
+		add("WALLET_HOST: %q is not an absolute url", c.External.Wallet.Host)
+	}
+	if c.External.Notification.Host == "" {
+		add("NOTIFICATION_HOST is required")
+	}
+	if len(c.External.ServiceToken.Secret) == 0 {
+		add("SERVICE_TOKEN_SECRET is required")
+	}
+
+	for _, grpcTLS := range c.grpcTLS() {
+		errs = append(errs, validateTLS(grpcTLS.prefix, grpcTLS.cfg)...)
+	}
+
+	if _, err := workflow.NewEncryptionCodecFromConfig(c.Encryption); err != nil {
+		add("PAYLOAD_ENCRYPTION_KEYS: %v", err)
+	}
+	if c.Temporal.CodecServerEnabled && !c.Encryption.Enabled() {
+		add("CODEC_SERVER_ENABLED needs PAYLOAD_ENCRYPTION_KEYS")
+	}
+
+	if _, err := c.Sandbox.ParseUsers(); err != nil {
+		add("SANDBOX_USERS: %v", err)
+	}
+
+	if c.Shutdown.ShutdownTimeout <= 0 {
+		add("SHUTDOWN_TIMEOUT: must be positive")
+	}
+	if c.Shutdown.DrainDelay < 0 {
+		add("SHUTDOWN_DRAIN_DELAY: must not be negative")
+	}
+
+	switch c.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+	default:
+		add("TRACING_EXPORTER: %q is not none, stdout or otlp", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("TRACING_SAMPLE_RATIO: %v is not between 0 and 1", c.Tracing.SampleRatio)
+	}
+
+	if c.App.Profile == ProfileProd {
+		errs = append(errs, c.validateProd()...)
+	}
+	return errors.Join(errs...)
+}
+
+// validateProd holds the rules that are fine to skip on a laptop but not in production.
+func (c *Config) validateProd() []error {
+	var errs []error
+	if len(c.External.ServiceToken.Secret) < 32 {
+		errs = append(errs, fmt.Errorf("SERVICE_TOKEN_SECRET must be at least 32 bytes in prod"))
+	}
+	if !c.Encryption.Enabled() {
+		errs = append(errs, fmt.Errorf("PAYLOAD_ENCRYPTION_KEYS is required in prod"))
+	}
+	for _, grpcTLS := range c.grpcTLS() {
+		if grpcTLS.cfg.Mode == helpers.TLSModePlaintext || grpcTLS.cfg.Mode == "" {
+			errs = append(errs, fmt.Errorf("%s_TLS_MODE: plaintext grpc is not allowed in prod", grpcTLS.prefix))
+		}
+	}
+	if c.Log.Format != helpers.LogFormatJSON {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be json in prod"))
+	}
+	if c.DB.Driver == helpers.DBDriverSQLite {
+		errs = append(errs, fmt.Errorf("DB_DRIVER=sqlite is for local runs and tests, not prod"))
+	}
+	return errs
+}
+
+// validateWorker checks the TEMPORAL_WORKER_* settings of one task queue, named by the queue
+// since a type overrides the shared settings.
+func validateWorker(q workflow.TaskQueue, add func(format string, args ...any)) {
+	if q.Name == "" {
+		add("TEMPORAL_TASK_QUEUE: task queue names must not be empty")
+		return
+	}
+	w := q.Worker
+	if w.MaxConcurrentActivities <= 0 || w.MaxConcurrentWorkflowTasks <= 0 {
+		add("task queue %s: TEMPORAL_WORKER_MAX_CONCURRENT_*: must be positive", q.Name)
+	}
+	if w.ActivityPollers <= 0 || w.WorkflowTaskPollers <= 0 {
+		add("task queue %s: TEMPORAL_WORKER_*_POLLERS: must be positive", q.Name)
+	}
+	// the sdk needs two workflow task pollers, one for the normal and one for the sticky queue
+	if w.WorkflowTaskPollers == 1 {
+		add("task queue %s: TEMPORAL_WORKER_WORKFLOW_TASK_POLLERS: must be at least 2", q.Name)
+	}
+	if w.ActivitiesPerSecond < 0 {
+		add("task queue %s: TEMPORAL_WORKER_ACTIVITIES_PER_SECOND: must not be negative, 0 is unlimited", q.Name)
+	}
+}
+
+type prefixedTLS struct {
+	prefix string
+	cfg    helpers.TLSConfig
+}
+
+func (c *Config) grpcTLS() []prefixedTLS {
+	return []prefixedTLS{
+		{"UMS", c.External.UMS.TLS},
+		{"NOTIFICATION", c.External.Notification.TLS},
+		{"GRPC_SERVER", c.GRPCServer.TLS},
+	}
+}
+
+func validateTLS(prefix string, cfg helpers.TLSConfig) []error {
+	var errs []error
+	switch cfg.Mode {
+	case helpers.TLSModePlaintext, "":
+		return nil
+	case helpers.TLSModeTLS, helpers.TLSModeMTLS:
+	default:
+		return []error{fmt.Errorf("%s_TLS_MODE: %q is not plaintext, tls or mtls", prefix, cfg.Mode)}
+	}
+	for _, file := range []struct{ key, path string }{
+		{"CA_FILE", cfg.CAFile},
+		{"CERT_FILE", cfg.CertFile},
+		{"KEY_FILE", cfg.KeyFile},
+	} {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			errs = append(errs, fmt.Errorf("%s_TLS_%s: %v", prefix, file.key, err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,282 @@
+// Package fakes holds in-memory stand-ins for the external services, the transaction repo
+// and temporal. They keep enough state to behave like the real dependencies inside one
+// process, for tests and local runs, and nothing survives a restart.
+package fakes
+
+import (
+	"context"
+	"ewallet-topup/external"
+	"ewallet-topup/internal/interfaces"
+	"ewallet-topup/internal/models"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	HoldStatusHeld     = external.HoldStatusHeld
+	HoldStatusCaptured = external.HoldStatusCaptured
+	HoldStatusVoided   = external.HoldStatusVoided
+)
+
+type hold struct {
+	id        string
+	reference string
+	userID    int64
+	amount    float64
+	status    string
+}
+
+// External implements interfaces.IExternal with an in-memory wallet, a token table in place
+// of ums and a notification sink. A reference moves money or places a hold once, repeating it
+// returns external.ErrDuplicateReference like the real wallet. Set Errors to make a method fail, e.g. Errors["DebitBalance"] = external.ErrWalletTransient.
+type External struct {
+	mu sync.Mutex
+	// Tokens maps a bearer token, with or without the Bearer prefix, to its user
+	Tokens map[string]models.TokenData
+	// Balances is the available balance by user id, held amounts are already taken out
+	Balances map[int64]float64
+	Errors   map[string]error
+
+	holds         map[string]*hold
+	references    map[string]bool
+	notifications []external.TransactionNotification
+}
+
+var _ interfaces.IExternal = (*External)(nil)
+
+func NewExternal() *External {
+	return &External{
+		Tokens:     map[string]models.TokenData{},
+		Balances:   map[int64]float64{},
+		Errors:     map[string]error{},
+		holds:      map[string]*hold{},
+		references: map[string]bool{},
+	}
+}
+
+// AddUser registers a token for user and sets the user balance.
+func (e *External) AddUser(token string, user models.TokenData, balance float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.Tokens[strings.TrimPrefix(token, "Bearer ")] = user
+	e.Balances[user.UserID] = balance
+}
+
+func (e *External) Balance(userID int64) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.Balances[userID]
+}
+
+// Notifications returns the transaction notifications sent so far, oldest first.
+func (e *External) Notifications() []external.TransactionNotification {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]external.TransactionNotification(nil), e.notifications...)
+}
+
+// HoldStatus returns the status of the hold placed for reference, empty if there is none.
+func (e *External) HoldStatus(reference string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if h := e.findHold(reference); h != nil {
+		return h.status
+	}
+	return ""
+}
+
+func (e *External) fail(method string) error {
+	return e.Errors[method]
+}
+
+func walletError(kind error, status int, message string) error {
+	err := &external.WalletError{Kind: kind, StatusCode: status, Message: message}
+	switch kind {
+	case external.ErrInsufficientBalance:
+		err.Code = external.WalletCodeInsufficientBalance
+	case external.ErrDuplicateReference:
+		err.Code = external.WalletCodeDuplicateReference
+	}
+	return err
+}
+
+func (e *External) ValidateToken(_ context.Context, token string) (models.TokenData, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.fail("ValidateToken"); err != nil {
+		return models.TokenData{}, err
+	}
+	user, ok := e.Tokens[strings.TrimPrefix(token, "Bearer ")]
+	if !ok {
+		return models.TokenData{}, fmt.Errorf("token is not valid")
+	}
+	return user, nil
+}
+
+func (e *External) InvalidateToken(string) {}
+
+func (e *External) CreditBalance(_ context.Context, req external.UpdateBalance) (*external.UpdateBalanceResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.fail("CreditBalance"); err != nil {
+		return nil, err
+	}
+	if e.references[req.Reference] {
+		return nil, walletError(external.ErrDuplicateReference, http.StatusConflict, "reference already used")
+	}
+	e.references[req.Reference] = true
+	e.Balances[req.UserID] += req.Amount
+	return &external.UpdateBalanceResponse{Message: "success", Data: external.WalletBalance{Balance: e.Balances[req.UserID]}}, nil
+}
+
+func (e *External) DebitBalance(_ context.Context, req external.UpdateBalance) (*external.UpdateBalanceResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.fail("DebitBalance"); err != nil {
+		return nil, err
+	}
+	if e.references[req.Reference] {
+		return nil, walletError(external.ErrDuplicateReference, http.StatusConflict, "reference already used")
+	}
+	if e.Balances[req.UserID] < req.Amount {
+		return nil, walletError(external.ErrInsufficientBalance, http.StatusUnprocessableEntity, "insufficient balance")
+	}
+	e.references[req.Reference] = true
+	e.Balances[req.UserID] -= req.Amount
+	return &external.UpdateBalanceResponse{Message: "success", Data: external.WalletBalance{Balance: e.Balances[req.UserID]}}, nil
+}
+
+func (e *External) GetBalance(_ context.Context, token string) (*external.BalanceResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.fail("GetBalance"); err != nil {
+		return nil, err
+	}
+	user, ok := e.Tokens[strings.TrimPrefix(token, "Bearer ")]
+	if !ok {
+		return nil, walletError(external.ErrUnauthorized, http.StatusUnauthorized, "unknown token")
+	}
+	return &external.BalanceResponse{Message: "success", Data: external.WalletBalance{Balance: e.Balances[user.UserID]}}, nil
+}
+
+func (e *External) HoldBalance(_ context.Context, req external.HoldRequest) (*external.HoldResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.fail("HoldBalance"); err != nil {
+		return nil, err
+	}
+	if e.findHold(req.Reference) != nil {
+		return nil, walletError(external.ErrDuplicateReference, http.StatusConflict, "reference already used")
+	}
+	if e.Balances[req.UserID] < req.Amount {
+		return nil, walletError(external.ErrInsufficientBalance, http.StatusUnprocessableEntity, "insufficient balance")
+	}
+	h := &hold{
+		id:        fmt.Sprintf("hold-%d", len(e.holds)+1),
+		reference: req.Reference,
+		userID:    req.UserID,
+		amount:    req.Amount,
+		status:    HoldStatusHeld,
+	}
+	e.holds[h.id] = h
+	e.Balances[req.UserID] -= req.Amount
+	return e.holdResponse(h), nil
+}
+
+func (e *External) FindHold(_ context.Context, req external.HoldActionRequest) (*external.HoldResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.fail("FindHold"); err != nil {
+		return nil, err
+	}
+	h := e.findHold(req.Reference)
+	if h == nil {
+		return nil, walletError(fmt.Errorf("wallet: got error response %d", http.StatusNotFound), http.StatusNotFound, "hold not found")
+	}
+	return e.holdResponse(h), nil
+}
+
+func (e *External) findHold(reference string) *hold {
+	for _, h := range e.holds {
+		if h.reference == reference {
+			return h
+		}
+	}
+	return nil
+}
+
+func (e *External) CaptureHold(_ context.Context, holdID string, _ external.HoldActionRequest) (*external.HoldResponse, error) {
+	return e.settleHold("CaptureHold", holdID, HoldStatusCaptured)
+}
+
+func (e *External) VoidHold(_ context.Context, holdID string, _ external.HoldActionRequest) (*external.HoldResponse, error) {
+	return e.settleHold("VoidHold", holdID, HoldStatusVoided)
+}
+
+func (e *External) settleHold(method, holdID, status string) (*external.HoldResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.fail(method); err != nil {
+		return nil, err
+	}
+	h, ok := e.holds[holdID]
+	if !ok {
+		return nil, walletError(external.ErrWalletTransient, http.StatusNotFound, "hold not found")
+	}
+	if h.status != HoldStatusHeld {
+		return nil, walletError(external.ErrDuplicateReference, http.StatusConflict, "hold is "+h.status)
+	}
+	h.status = status
+	if status == HoldStatusVoided {
+		e.Balances[h.userID] += h.amount
+	}
+	return e.holdResponse(h), nil
+}
+
+func (e *External) holdResponse(h *hold) *external.HoldResponse {
+	return &external.HoldResponse{Message: "success", Data: external.WalletHold{
+		HoldID:  h.id,
+		Status:  h.status,
+		Balance: e.Balances[h.userID],
+	}}
+}
+
+func (e *External) NotifyUserRegistered(int64, string, string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.fail("NotifyUserRegistered")
+}
+
+func (e *External) NotifyTransaction(_ context.Context, data external.TransactionNotification) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.fail("NotifyTransaction"); err != nil {
+		return err
+	}
+	e.notifications = append(e.notifications, data)
+	return nil
+}
+
+func (e *External) BreakerStates() []external.BreakerSnapshot {
+	return []external.BreakerSnapshot{}
+}
+
+func (e *External) PingWallet(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.fail("PingWallet")
+}
+
+func (e *External) PingUMS(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.fail("PingUMS")
+}
+
+func (e *External) PingNotification(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.fail("PingNotification")
+}
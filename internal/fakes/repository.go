@@ -0,0 +1,141 @@
+package fakes
+
+import (
+	"context"
+	"ewallet-topup/internal/interfaces"
+	"ewallet-topup/internal/models"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TransactionRepo implements interfaces.ITransactionRepo on a map keyed by reference.
+// Missing rows return gorm.ErrRecordNotFound like the gorm repo. InTx runs fn against the
+// same map under a lock, so it serializes transactions but does not roll back on error;
+// use an sqlite backed repository.TransactionRepo where rollback matters.
+type TransactionRepo struct {
+	mu     sync.Mutex
+	txMu   sync.Mutex
+	rows   map[string]models.Transaction
+	nextID int64
+	// Now stamps CreatedAt and UpdatedAt, time.Now when nil
+	Now func() time.Time
+}
+
+var _ interfaces.ITransactionRepo = (*TransactionRepo)(nil)
+
+func NewTransactionRepo() *TransactionRepo {
+	return &TransactionRepo{rows: map[string]models.Transaction{}}
+}
+
+func (r *TransactionRepo) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+func (r *TransactionRepo) Create(ctx context.Context, trx *models.Transaction) error {
+	created, err := r.CreateIfNotExists(ctx, trx)
+	if err == nil && !created {
+		return gorm.ErrDuplicatedKey
+	}
+	return err
+}
+
+func (r *TransactionRepo) CreateIfNotExists(_ context.Context, trx *models.Transaction) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.rows[trx.Reference]; ok {
+		return false, nil
+	}
+	r.nextID++
+	trx.ID = r.nextID
+	trx.CreatedAt = r.now()
+	trx.UpdatedAt = trx.CreatedAt
+	r.rows[trx.Reference] = *trx
+	return true, nil
+}
+
+func (r *TransactionRepo) InTx(_ context.Context, fn func(repo interfaces.ITransactionRepo) error) error {
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+	return fn(r)
+}
+
+func (r *TransactionRepo) FindByReference(_ context.Context, ref string) (*models.Transaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	trx, ok := r.rows[ref]
+	if !ok {
+		return &models.Transaction{}, gorm.ErrRecordNotFound
+	}
+	return &trx, nil
+}
+
+func (r *TransactionRepo) FindByReferenceForUpdate(ctx context.Context, ref string) (*models.Transaction, error) {
+	return r.FindByReference(ctx, ref)
+}
+
+func (r *TransactionRepo) FindPendingCreatedBefore(_ context.Context, before time.Time, limit int) ([]models.Transaction, error) {
+	return r.find(limit, func(trx models.Transaction) bool {
+		return trx.Status == models.TransactionStatusPending && trx.CreatedAt.Before(before)
+	}), nil
+}
+
+func (r *TransactionRepo) FindCreatedSince(_ context.Context, since time.Time, limit int) ([]models.Transaction, error) {
+	return r.find(limit, func(trx models.Transaction) bool {
+		return !trx.CreatedAt.Before(since)
+	}), nil
+}
+
+// find returns the matching rows oldest first, like the gorm queries ordered by created_at.
+func (r *TransactionRepo) find(limit int, match func(models.Transaction) bool) []models.Transaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var trxs []models.Transaction
+	for _, trx := range r.rows {
+		if match(trx) {
+			trxs = append(trxs, trx)
+		}
+	}
+	sort.Slice(trxs, func(i, j int) bool { return trxs[i].ID < trxs[j].ID })
+	if len(trxs) > limit {
+		trxs = trxs[:limit]
+	}
+	return trxs
+}
+
+func (r *TransactionRepo) update(ref string, fn func(trx *models.Transaction)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	trx, ok := r.rows[ref]
+	if !ok {
+		// gorm reports no error when an update matches no row
+		return nil
+	}
+	fn(&trx)
+	trx.UpdatedAt = r.now()
+	r.rows[ref] = trx
+	return nil
+}
+
+func (r *TransactionRepo) UpdateStatus(_ context.Context, reference string, status models.TransactionStatus, reason *string) error {
+	return r.update(reference, func(trx *models.Transaction) {
+		trx.Status = status
+		if reason != nil {
+			info := *reason
+			trx.AdditionalInfo = &info
+		}
+	})
+}
+
+func (r *TransactionRepo) UpdateBalanceAfter(_ context.Context, reference string, balance float64) error {
+	return r.update(reference, func(trx *models.Transaction) { trx.BalanceAfter = &balance })
+}
+
+func (r *TransactionRepo) UpdateHoldID(_ context.Context, reference string, holdID string) error {
+	return r.update(reference, func(trx *models.Transaction) { trx.HoldID = &holdID })
+}
@@ -0,0 +1,85 @@
+package fakes
+
+import (
+	"context"
+	"ewallet-topup/internal/interfaces"
+	"ewallet-topup/internal/models"
+	"sync"
+)
+
+// Call is one recorded TransactionService call.
+type Call struct {
+	Method    string
+	Reference string
+}
+
+// TransactionService implements interfaces.ITransactionService for api and activity tests
+// that only look at which calls were made. Errors makes a method fail by name, SendNotification
+// has no error to return and is only recorded.
+type TransactionService struct {
+	mu     sync.Mutex
+	calls  []Call
+	Errors map[string]error
+}
+
+var _ interfaces.ITransactionService = (*TransactionService)(nil)
+
+func NewTransactionService() *TransactionService {
+	return &TransactionService{Errors: map[string]error{}}
+}
+
+// Calls returns the recorded calls in order.
+func (s *TransactionService) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+func (s *TransactionService) record(method, reference string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, Call{Method: method, Reference: reference})
+	return s.Errors[method]
+}
+
+func (s *TransactionService) UpdateStatus(_ context.Context, ref string, _ models.TransactionStatus, _ *string) error {
+	return s.record("UpdateStatus", ref)
+}
+
+func (s *TransactionService) CreatePending(_ context.Context, req models.CreateTransactionRequest) (*models.Transaction, error) {
+	if err := s.record("CreatePending", req.Referance); err != nil {
+		return nil, err
+	}
+	return &models.Transaction{
+		UserID:      req.UserID,
+		Amount:      float64(req.Amount),
+		Type:        models.TransactionType(req.Type),
+		Status:      models.TransactionStatusPending,
+		Reference:   req.Referance,
+		Description: req.Description,
+	}, nil
+}
+
+func (s *TransactionService) CheckSufficientBalance(context.Context, string, float64) error {
+	return s.record("CheckSufficientBalance", "")
+}
+
+func (s *TransactionService) DebitWallet(_ context.Context, trx *models.Transaction) error {
+	return s.record("DebitWallet", trx.Reference)
+}
+
+func (s *TransactionService) CreditWallet(_ context.Context, trx *models.Transaction) error {
+	return s.record("CreditWallet", trx.Reference)
+}
+
+func (s *TransactionService) CaptureHold(_ context.Context, trx *models.Transaction) error {
+	return s.record("CaptureHold", trx.Reference)
+}
+
+func (s *TransactionService) VoidHold(_ context.Context, trx *models.Transaction) error {
+	return s.record("VoidHold", trx.Reference)
+}
+
+func (s *TransactionService) SendNotification(_ context.Context, trx *models.Transaction, _ models.TokenData) {
+	_ = s.record("SendNotification", trx.Reference)
+}
@@ -0,0 +1,39 @@
+package fakes
+
+import (
+	"context"
+	"ewallet-topup/helpers"
+	"ewallet-topup/internal/migrations"
+	"log/slog"
+
+	"gorm.io/gorm"
+)
+
+// OpenSQLite returns a migrated in-memory sqlite database for repository.TransactionRepo,
+// so repo and activity code runs against real sql without a server. It needs a cgo build.
+// The single pooled connection is never recycled, closing it drops the database.
+func OpenSQLite(logger *slog.Logger) (*gorm.DB, helpers.DBPools, error) {
+	db, pools, err := helpers.SetupDB(helpers.DBConfig{
+		Driver: helpers.DBDriverSQLite,
+		Name:   ":memory:",
+		Pool:   helpers.DBPoolConfig{MaxOpenConns: 1, MaxIdleConns: 1},
+	}, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		_ = pools.Close()
+		return nil, nil, err
+	}
+	migrator, err := migrations.NewMigrator(sqlDB, helpers.DBDriverSQLite, logger)
+	if err != nil {
+		_ = pools.Close()
+		return nil, nil, err
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		_ = pools.Close()
+		return nil, nil, err
+	}
+	return db, pools, nil
+}
@@ -0,0 +1,215 @@
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	commonpb "go.temporal.io/api/common/v1"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+)
+
+// StartedWorkflow is a recorded ExecuteWorkflow call.
+type StartedWorkflow struct {
+	Options client.StartWorkflowOptions
+	Args    []interface{}
+}
+
+// SentSignal is a recorded SignalWorkflow call.
+type SentSignal struct {
+	WorkflowID string
+	Name       string
+	Arg        interface{}
+}
+
+// SentUpdate is a recorded UpdateWorkflow call.
+type SentUpdate struct {
+	WorkflowID string
+	UpdateID   string
+	Name       string
+	Args       []interface{}
+}
+
+// Temporal is a client.Client for api tests. It records ExecuteWorkflow, SignalWorkflow and
+// UpdateWorkflow without running anything. QueryWorkflow and UpdateWorkflow answer with
+// Results, unless UpdateErrors fails the update. DescribeWorkflowExecution reports the
+// Statuses entry, running by default, without pending activities. CheckHealth succeeds unless
+// Err is set. Any other method panics on the embedded nil client. Workflow behaviour is tested
+// with the temporal testsuite instead, see internal/workflow/transaction/workflow_test.go.
+type Temporal struct {
+	client.Client
+
+	mu      sync.Mutex
+	started []StartedWorkflow
+	signals []SentSignal
+	updates []SentUpdate
+	// Results answers queries and updates by workflow id, e.g. a transaction.TransactionState.
+	// Unknown ids fail with serviceerror.NotFound, an error value is returned as the failure
+	Results map[string]interface{}
+	// Statuses overrides the status DescribeWorkflowExecution reports by workflow id
+	Statuses map[string]enumspb.WorkflowExecutionStatus
+	// UpdateErrors fails the Get of accepted updates by workflow id, e.g. with a
+	// client.WorkflowUpdateServiceTimeoutOrCanceledError for an update still running
+	UpdateErrors map[string]error
+	// Err fails every call when set
+	Err error
+}
+
+var _ client.Client = (*Temporal)(nil)
+
+func NewTemporal() *Temporal {
+	return &Temporal{
+		Results:      map[string]interface{}{},
+		Statuses:     map[string]enumspb.WorkflowExecutionStatus{},
+		UpdateErrors: map[string]error{},
+	}
+}
+
+func (t *Temporal) Started() []StartedWorkflow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]StartedWorkflow(nil), t.started...)
+}
+
+func (t *Temporal) Signals() []SentSignal {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]SentSignal(nil), t.signals...)
+}
+
+func (t *Temporal) ExecuteWorkflow(_ context.Context, options client.StartWorkflowOptions, _ interface{}, args ...interface{}) (client.WorkflowRun, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Err != nil {
+		return nil, t.Err
+	}
+	t.started = append(t.started, StartedWorkflow{Options: options, Args: args})
+	return &workflowRun{id: options.ID, runID: fmt.Sprintf("run-%d", len(t.started))}, nil
+}
+
+func (t *Temporal) SignalWorkflow(_ context.Context, workflowID string, _ string, signalName string, arg interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Err != nil {
+		return t.Err
+	}
+	t.signals = append(t.signals, SentSignal{WorkflowID: workflowID, Name: signalName, Arg: arg})
+	return nil
+}
+
+func (t *Temporal) Updates() []SentUpdate {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]SentUpdate(nil), t.updates...)
+}
+
+// result encodes the Results entry of workflowID like a worker would.
+func (t *Temporal) result(workflowID string) (*commonpb.Payloads, error) {
+	if t.Err != nil {
+		return nil, t.Err
+	}
+	res, ok := t.Results[workflowID]
+	if !ok {
+		return nil, serviceerror.NewNotFound("workflow not found for ID: " + workflowID)
+	}
+	if err, ok := res.(error); ok {
+		return nil, err
+	}
+	return converter.GetDefaultDataConverter().ToPayloads(res)
+}
+
+func (t *Temporal) QueryWorkflow(_ context.Context, workflowID string, _ string, _ string, _ ...interface{}) (converter.EncodedValue, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	payloads, err := t.result(workflowID)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewValue(payloads), nil
+}
+
+func (t *Temporal) UpdateWorkflow(_ context.Context, options client.UpdateWorkflowOptions) (client.WorkflowUpdateHandle, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.updates = append(t.updates, SentUpdate{
+		WorkflowID: options.WorkflowID,
+		UpdateID:   options.UpdateID,
+		Name:       options.UpdateName,
+		Args:       options.Args,
+	})
+	payloads, err := t.result(options.WorkflowID)
+	if err != nil {
+		return nil, err
+	}
+	return &updateHandle{
+		workflowID: options.WorkflowID,
+		updateID:   options.UpdateID,
+		value:      client.NewValue(payloads),
+		err:        t.UpdateErrors[options.WorkflowID],
+	}, nil
+}
+
+func (t *Temporal) DescribeWorkflowExecution(_ context.Context, workflowID, runID string) (*workflowservice.DescribeWorkflowExecutionResponse, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.result(workflowID); err != nil {
+		return nil, err
+	}
+	status, ok := t.Statuses[workflowID]
+	if !ok {
+		status = enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING
+	}
+	return &workflowservice.DescribeWorkflowExecutionResponse{
+		WorkflowExecutionInfo: &workflowpb.WorkflowExecutionInfo{
+			Execution: &commonpb.WorkflowExecution{WorkflowId: workflowID, RunId: runID},
+			Status:    status,
+		},
+	}, nil
+}
+
+func (t *Temporal) CheckHealth(context.Context, *client.CheckHealthRequest) (*client.CheckHealthResponse, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Err != nil {
+		return nil, t.Err
+	}
+	return &client.CheckHealthResponse{}, nil
+}
+
+func (t *Temporal) Close() {}
+
+type workflowRun struct {
+	client.WorkflowRun
+	id    string
+	runID string
+}
+
+func (r *workflowRun) GetID() string    { return r.id }
+func (r *workflowRun) GetRunID() string { return r.runID }
+
+func (r *workflowRun) Get(context.Context, interface{}) error {
+	return fmt.Errorf("fake workflow %s never completes", r.id)
+}
+
+type updateHandle struct {
+	workflowID string
+	updateID   string
+	value      converter.EncodedValue
+	err        error
+}
+
+func (h *updateHandle) WorkflowID() string { return h.workflowID }
+func (h *updateHandle) RunID() string      { return "" }
+func (h *updateHandle) UpdateID() string   { return h.updateID }
+
+func (h *updateHandle) Get(_ context.Context, valuePtr interface{}) error {
+	if h.err != nil {
+		return h.err
+	}
+	return h.value.Get(valuePtr)
+}
@@ -0,0 +1,11 @@
+package interfaces
+
+import (
+	"context"
+	"ewallet-topup/internal/models"
+)
+
+// ITokenVerifier is implemented by the UMS grpc check (External) and the local JWT verifier.
+type ITokenVerifier interface {
+	ValidateToken(ctx context.Context, token string) (models.TokenData, error)
+}
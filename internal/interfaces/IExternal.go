@@ -8,7 +8,18 @@ import (
 
 type IExternal interface {
 	ValidateToken(ctx context.Context, token string) (models.TokenData, error)
-	CreditBalance(ctx context.Context, token string, req external.UpdateBalance) (*external.UpdateBalanceResponse, error)
-	DebitBalance(ctx context.Context, token string, req external.UpdateBalance) (*external.UpdateBalanceResponse, error)
+	InvalidateToken(token string)
+	CreditBalance(ctx context.Context, req external.UpdateBalance) (*external.UpdateBalanceResponse, error)
+	DebitBalance(ctx context.Context, req external.UpdateBalance) (*external.UpdateBalanceResponse, error)
+	GetBalance(ctx context.Context, token string) (*external.BalanceResponse, error)
+	HoldBalance(ctx context.Context, req external.HoldRequest) (*external.HoldResponse, error)
+	FindHold(ctx context.Context, req external.HoldActionRequest) (*external.HoldResponse, error)
+	CaptureHold(ctx context.Context, holdID string, req external.HoldActionRequest) (*external.HoldResponse, error)
+	VoidHold(ctx context.Context, holdID string, req external.HoldActionRequest) (*external.HoldResponse, error)
 	NotifyUserRegistered(userID int64, email, fullName string) error
+	NotifyTransaction(ctx context.Context, data external.TransactionNotification) error
+	BreakerStates() []external.BreakerSnapshot
+	PingWallet(ctx context.Context) error
+	PingUMS(ctx context.Context) error
+	PingNotification(ctx context.Context) error
 }
@@ -1,13 +1,25 @@
 package interfaces
 
-import "github.com/gin-gonic/gin"
+import (
+	"context"
+	"ewallet-topup/external"
+	"ewallet-topup/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
 
 type IHealthcheckServices interface {
 	HealthcheckServices() (string, error)
+	CircuitBreakers() []external.BreakerSnapshot
+	Readiness(ctx context.Context) models.HealthReport
 }
 
 type IHealthcheckRepo interface {
+	Ping(ctx context.Context) error
+	PoolStats() []models.DBPoolStats
 }
 type IHealthcheckAPI interface {
 	HealthcheckHandlerHTTP(c *gin.Context)
+	LivenessHandlerHTTP(c *gin.Context)
+	ReadinessHandlerHTTP(c *gin.Context)
 }
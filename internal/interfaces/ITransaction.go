@@ -3,28 +3,39 @@ package interfaces
 import (
 	"context"
 	"ewallet-topup/internal/models"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type ITransactionRepo interface {
 	Create(ctx context.Context, trx *models.Transaction) error
+	CreateIfNotExists(ctx context.Context, trx *models.Transaction) (bool, error)
+	InTx(ctx context.Context, fn func(repo ITransactionRepo) error) error
 	FindByReference(ctx context.Context, ref string) (*models.Transaction, error)
 	FindByReferenceForUpdate(ctx context.Context, ref string) (*models.Transaction, error)
+	FindPendingCreatedBefore(ctx context.Context, before time.Time, limit int) ([]models.Transaction, error)
+	FindCreatedSince(ctx context.Context, since time.Time, limit int) ([]models.Transaction, error)
 	UpdateStatus(ctx context.Context, reference string, status models.TransactionStatus, reason *string) error
+	UpdateBalanceAfter(ctx context.Context, reference string, balance float64) error
+	UpdateHoldID(ctx context.Context, reference string, holdID string) error
 }
 
 type ITransactionService interface {
 	UpdateStatus(ctx context.Context, ref string, status models.TransactionStatus, reason *string) error
 	CreatePending(ctx context.Context, req models.CreateTransactionRequest) (*models.Transaction, error)
-	DebitWallet(ctx context.Context, trx *models.Transaction, token string) error
-	CreditWallet(ctx context.Context, trx *models.Transaction, token string) error
+	CheckSufficientBalance(ctx context.Context, token string, amount float64) error
+	DebitWallet(ctx context.Context, trx *models.Transaction) error
+	CreditWallet(ctx context.Context, trx *models.Transaction) error
+	CaptureHold(ctx context.Context, trx *models.Transaction) error
+	VoidHold(ctx context.Context, trx *models.Transaction) error
 	SendNotification(ctx context.Context, trx *models.Transaction, user models.TokenData)
 }
 
 type ITransactionAPI interface {
 	CreateTransaction(c *gin.Context)
 	UpdateStatusTransaction(c *gin.Context)
+	GetTransactionState(c *gin.Context)
 	//RefundTransaction(c *gin.Context)
 	//GetTransaction(c *gin.Context)
 	//GetTransactionDetail(c *gin.Context)
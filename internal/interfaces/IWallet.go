@@ -0,0 +1,15 @@
+package interfaces
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+)
+
+type IWalletService interface {
+	GetBalance(ctx context.Context, token string) (float64, error)
+}
+
+type IWalletAPI interface {
+	GetBalance(c *gin.Context)
+}
@@ -0,0 +1,169 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Service is a long running part of the process, e.g. the http server or the temporal worker.
+type Service struct {
+	Name string
+	// Run blocks until the service stops. http.ErrServerClosed is treated as a clean stop.
+	Run func() error
+	// Stop asks Run to return. App waits for Run after Stop, bounded by the shutdown deadline.
+	Stop func(ctx context.Context) error
+}
+
+type closer struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+type Config struct {
+	// ShutdownTimeout bounds draining services and closing clients together
+	ShutdownTimeout time.Duration
+	// DrainDelay keeps serving after readiness turns false, so load balancers stop routing first
+	DrainDelay time.Duration
+}
+
+// App starts the services and, on SIGINT/SIGTERM or when one of them fails, shuts everything
+// down in order: OnShutdown hooks (readiness), drain delay, stop services, then closers.
+type App struct {
+	cfg        Config
+	services   []Service
+	onShutdown []func()
+	closers    []closer
+	log        *slog.Logger
+}
+
+func New(cfg Config, logger *slog.Logger) *App {
+	return &App{cfg: cfg, log: logger}
+}
+
+func (a *App) Go(s Service) {
+	a.services = append(a.services, s)
+}
+
+// OnShutdown runs fn as soon as shutdown starts, before anything stops accepting traffic.
+func (a *App) OnShutdown(fn func()) {
+	a.onShutdown = append(a.onShutdown, fn)
+}
+
+// Close registers a client to close after every service stopped. Closers run in reverse
+// order of registration, like defer, so register a dependency before what uses it.
+func (a *App) Close(name string, fn func(ctx context.Context) error) {
+	a.closers = append(a.closers, closer{name: name, fn: fn})
+}
+
+// Run blocks until shutdown finished. It returns the error that triggered the shutdown, if a
+// service failed, joined with anything that went wrong while stopping.
+func (a *App) Run(ctx context.Context) error {
+	ctx, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	log := a.log
+	done := make([]chan struct{}, len(a.services))
+	failed := make(chan error, len(a.services))
+	for i, s := range a.services {
+		done[i] = make(chan struct{})
+		go func(s Service, done chan struct{}) {
+			defer close(done)
+			log.Info("service started", "service", s.Name)
+			if err := s.Run(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				failed <- fmt.Errorf("%s stopped: %w", s.Name, err)
+			}
+		}(s, done[i])
+	}
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		log.Info("shutdown signal received")
+	case runErr = <-failed:
+		log.Error("service failed, shutting down", "error", runErr)
+	}
+	stopSignals()
+
+	return errors.Join(runErr, a.shutdown(done))
+}
+
+// Shutdown runs the OnShutdown hooks and closers without waiting for a signal. It is meant
+// for one-shot commands, services registered through Go are stopped without waiting for Run.
+func (a *App) Shutdown() error {
+	// none of the services ran, there is nothing to wait for after Stop
+	done := make([]chan struct{}, len(a.services))
+	for i := range done {
+		done[i] = make(chan struct{})
+		close(done[i])
+	}
+	return a.shutdown(done)
+}
+
+func (a *App) shutdown(done []chan struct{}) error {
+	log := a.log
+	start := time.Now()
+
+	for _, fn := range a.onShutdown {
+		fn()
+	}
+	if a.cfg.DrainDelay > 0 {
+		log.Info("waiting for load balancers to drain", "delay", a.cfg.DrainDelay.String())
+		time.Sleep(a.cfg.DrainDelay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.ShutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for i, s := range a.services {
+		wg.Add(1)
+		go func(s Service, done chan struct{}) {
+			defer wg.Done()
+			err := s.Stop(ctx)
+			if err == nil {
+				select {
+				case <-done:
+				case <-ctx.Done():
+					err = ctx.Err()
+				}
+			}
+			if err != nil {
+				log.Error("service did not stop cleanly", "service", s.Name, "error", err)
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				return
+			}
+			log.Info("service stopped", "service", s.Name)
+		}(s, done[i])
+	}
+	wg.Wait()
+
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		c := a.closers[i]
+		if err := c.fn(ctx); err != nil {
+			log.Error("failed to close", "client", c.name, "error", err)
+			errs = append(errs, err)
+			continue
+		}
+		log.Debug("closed", "client", c.name)
+	}
+
+	log.Info("shutdown complete", "duration", time.Since(start).String())
+	return errors.Join(errs...)
+}
+
+// HTTPServer wraps srv as a Service, Stop stops accepting connections and waits for in-flight requests.
+func HTTPServer(name string, srv *http.Server) Service {
+	return Service{Name: name, Run: srv.ListenAndServe, Stop: srv.Shutdown}
+}
@@ -0,0 +1,121 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder collects the order services and closers were stopped in.
+type recorder struct {
+	mu    sync.Mutex
+	steps []string
+}
+
+func (r *recorder) add(step string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.steps = append(r.steps, step)
+}
+
+func (r *recorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.steps...)
+}
+
+func newTestApp() *App {
+	return New(Config{ShutdownTimeout: time.Second}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+// blockingService runs until Stop is called.
+func blockingService(name string, rec *recorder) Service {
+	stop := make(chan struct{})
+	var once sync.Once
+	return Service{
+		Name: name,
+		Run: func() error {
+			<-stop
+			return nil
+		},
+		Stop: func(context.Context) error {
+			rec.add("stop " + name)
+			once.Do(func() { close(stop) })
+			return nil
+		},
+	}
+}
+
+func TestShutdownWithoutRun(t *testing.T) {
+	rec := &recorder{}
+	app := newTestApp()
+	app.Go(blockingService("http", rec))
+	app.OnShutdown(func() { rec.add("hook") })
+	app.Close("db", func(context.Context) error { rec.add("close db"); return nil })
+	app.Close("temporal", func(context.Context) error { rec.add("close temporal"); return nil })
+
+	if err := app.Shutdown(); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	want := []string{"hook", "stop http", "close temporal", "close db"}
+	if got := rec.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("steps = %v, want %v", got, want)
+	}
+}
+
+func TestRunStopsEverythingWhenAServiceFails(t *testing.T) {
+	rec := &recorder{}
+	app := newTestApp()
+	app.Go(blockingService("http", rec))
+	boom := errors.New("boom")
+	app.Go(Service{
+		Name: "worker",
+		Run:  func() error { return boom },
+		Stop: func(context.Context) error { rec.add("stop worker"); return nil },
+	})
+	app.Close("db", func(context.Context) error { rec.add("close db"); return nil })
+
+	err := app.Run(context.Background())
+	if !errors.Is(err, boom) {
+		t.Fatalf("Run error = %v, want %v", err, boom)
+	}
+	steps := rec.get()
+	if len(steps) != 3 || steps[2] != "close db" {
+		t.Errorf("steps = %v, want both services stopped before closing db", steps)
+	}
+}
+
+func TestRunStopsOnContextCancel(t *testing.T) {
+	rec := &recorder{}
+	app := newTestApp()
+	app.Go(blockingService("http", rec))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := app.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got := rec.get(); !reflect.DeepEqual(got, []string{"stop http"}) {
+		t.Errorf("steps = %v", got)
+	}
+}
+
+func TestShutdownTimesOutOnStuckService(t *testing.T) {
+	app := New(Config{ShutdownTimeout: 50 * time.Millisecond}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	app.Go(Service{
+		Name: "stuck",
+		Run:  func() error { select {} },
+		Stop: func(context.Context) error { return nil },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := app.Run(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run error = %v, want deadline exceeded", err)
+	}
+}
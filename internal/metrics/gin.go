@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GinMiddleware records every request by its route template, not the raw path,
+// so references in the url do not blow up label cardinality.
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ObserveHTTP(c.Request.Method, route, c.Writer.Status(), time.Since(start).Seconds())
+	}
+}
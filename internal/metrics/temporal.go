@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.temporal.io/sdk/client"
+)
+
+// TemporalHandler adapts the temporal sdk metrics to prometheus. A metric keeps the
+// label names of its first use, later tags outside that set are dropped.
+type TemporalHandler struct {
+	registry *temporalRegistry
+	tags     map[string]string
+}
+
+type temporalRegistry struct {
+	mu         sync.Mutex
+	registerer prometheus.Registerer
+	counters   map[string]*prometheus.CounterVec
+	gauges     map[string]*prometheus.GaugeVec
+	timers     map[string]*prometheus.HistogramVec
+	labels     map[string][]string
+}
+
+func NewTemporalHandler() client.MetricsHandler {
+	return &TemporalHandler{
+		registry: &temporalRegistry{
+			registerer: Registry,
+			counters:   map[string]*prometheus.CounterVec{},
+			gauges:     map[string]*prometheus.GaugeVec{},
+			timers:     map[string]*prometheus.HistogramVec{},
+			labels:     map[string][]string{},
+		},
+		tags: map[string]string{},
+	}
+}
+
+func (h *TemporalHandler) WithTags(tags map[string]string) client.MetricsHandler {
+	merged := make(map[string]string, len(h.tags)+len(tags))
+	for k, v := range h.tags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return &TemporalHandler{registry: h.registry, tags: merged}
+}
+
+func (h *TemporalHandler) Counter(name string) client.MetricsCounter {
+	vec, labels := h.registry.counter(name, h.tags)
+	counter := vec.With(labelValues(labels, h.tags))
+	return counterFunc(func(d int64) { counter.Add(float64(d)) })
+}
+
+func (h *TemporalHandler) Gauge(name string) client.MetricsGauge {
+	vec, labels := h.registry.gauge(name, h.tags)
+	gauge := vec.With(labelValues(labels, h.tags))
+	return gaugeFunc(gauge.Set)
+}
+
+func (h *TemporalHandler) Timer(name string) client.MetricsTimer {
+	vec, labels := h.registry.timer(name, h.tags)
+	histogram := vec.With(labelValues(labels, h.tags))
+	return timerFunc(func(d time.Duration) { histogram.Observe(d.Seconds()) })
+}
+
+type counterFunc func(int64)
+
+func (f counterFunc) Inc(d int64) { f(d) }
+
+type gaugeFunc func(float64)
+
+func (f gaugeFunc) Update(v float64) { f(v) }
+
+type timerFunc func(time.Duration)
+
+func (f timerFunc) Record(d time.Duration) { f(d) }
+
+func (r *temporalRegistry) counter(name string, tags map[string]string) (*prometheus.CounterVec, []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if vec, ok := r.counters[name]; ok {
+		return vec, r.labels[name]
+	}
+	labels := labelNames(tags)
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: namespace, Name: metricName(name) + "_total", Help: "temporal " + name}, labels)
+	r.registerer.MustRegister(vec)
+	r.counters[name] = vec
+	r.labels[name] = labels
+	return vec, labels
+}
+
+func (r *temporalRegistry) gauge(name string, tags map[string]string) (*prometheus.GaugeVec, []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if vec, ok := r.gauges[name]; ok {
+		return vec, r.labels[name]
+	}
+	labels := labelNames(tags)
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: namespace, Name: metricName(name), Help: "temporal " + name}, labels)
+	r.registerer.MustRegister(vec)
+	r.gauges[name] = vec
+	r.labels[name] = labels
+	return vec, labels
+}
+
+func (r *temporalRegistry) timer(name string, tags map[string]string) (*prometheus.HistogramVec, []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if vec, ok := r.timers[name]; ok {
+		return vec, r.labels[name]
+	}
+	labels := labelNames(tags)
+	vec := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      metricName(name) + "_seconds",
+		Help:      "temporal " + name,
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, labels)
+	r.registerer.MustRegister(vec)
+	r.timers[name] = vec
+	r.labels[name] = labels
+	return vec, labels
+}
+
+func labelNames(tags map[string]string) []string {
+	names := make([]string, 0, len(tags))
+	for k := range tags {
+		names = append(names, metricName(k))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func labelValues(labels []string, tags map[string]string) prometheus.Labels {
+	values := make(prometheus.Labels, len(labels))
+	for _, l := range labels {
+		values[l] = ""
+	}
+	for k, v := range tags {
+		if _, ok := values[metricName(k)]; ok {
+			values[metricName(k)] = v
+		}
+	}
+	return values
+}
+
+func metricName(name string) string {
+	return strings.NewReplacer("-", "_", ".", "_", " ", "_").Replace(name)
+}
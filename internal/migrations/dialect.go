@@ -0,0 +1,113 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"ewallet-topup/helpers"
+	"fmt"
+	"time"
+)
+
+const (
+	lockName = "ewallet_topup_schema_migrations"
+	// lockKey is the postgres advisory lock key, any constant unique to this service
+	lockKey = 7_236_410_981
+)
+
+// dialect holds the driver specific sql of the migrator, the migration files themselves
+// live in a directory per driver.
+type dialect struct {
+	createVersionTable string
+	// markDirty inserts or flags the version row, args: version, name, applied_at
+	markDirty string
+	// markClean and deleteVersion take the version
+	markClean     string
+	deleteVersion string
+	lock          func(ctx context.Context, conn *sql.Conn, timeout time.Duration) error
+	unlock        func(conn *sql.Conn)
+}
+
+var dialects = map[string]dialect{
+	helpers.DBDriverMySQL: {
+		createVersionTable: "CREATE TABLE IF NOT EXISTS `schema_migrations` (" +
+			"`version` BIGINT NOT NULL PRIMARY KEY, " +
+			"`name` VARCHAR(255) NOT NULL, " +
+			"`dirty` BOOLEAN NOT NULL DEFAULT FALSE, " +
+			"`applied_at` DATETIME(3) NOT NULL)",
+		markDirty: "INSERT INTO `schema_migrations` (`version`, `name`, `dirty`, `applied_at`) VALUES (?, ?, TRUE, ?) " +
+			"ON DUPLICATE KEY UPDATE `dirty` = TRUE",
+		markClean:     "UPDATE `schema_migrations` SET `dirty` = FALSE WHERE `version` = ?",
+		deleteVersion: "DELETE FROM `schema_migrations` WHERE `version` = ?",
+		lock: func(ctx context.Context, conn *sql.Conn, timeout time.Duration) error {
+			var locked sql.NullInt64
+			err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, int(timeout.Seconds())).Scan(&locked)
+			if err != nil {
+				return err
+			}
+			if locked.Int64 != 1 {
+				return errLockTimeout
+			}
+			return nil
+		},
+		unlock: func(conn *sql.Conn) {
+			_, _ = conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", lockName)
+		},
+	},
+	helpers.DBDriverPostgres: {
+		createVersionTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT NOT NULL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			dirty BOOLEAN NOT NULL DEFAULT FALSE,
+			applied_at TIMESTAMPTZ NOT NULL)`,
+		markDirty: "INSERT INTO schema_migrations (version, name, dirty, applied_at) VALUES ($1, $2, TRUE, $3) " +
+			"ON CONFLICT (version) DO UPDATE SET dirty = TRUE",
+		markClean:     "UPDATE schema_migrations SET dirty = FALSE WHERE version = $1",
+		deleteVersion: "DELETE FROM schema_migrations WHERE version = $1",
+		// pg_advisory_lock has no timeout of its own, so poll the non blocking variant
+		lock: func(ctx context.Context, conn *sql.Conn, timeout time.Duration) error {
+			deadline := time.Now().Add(timeout)
+			for {
+				var locked bool
+				if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", lockKey).Scan(&locked); err != nil {
+					return err
+				}
+				if locked {
+					return nil
+				}
+				if time.Now().After(deadline) {
+					return errLockTimeout
+				}
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(time.Second):
+				}
+			}
+		},
+		unlock: func(conn *sql.Conn) {
+			_, _ = conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+		},
+	},
+	helpers.DBDriverSQLite: {
+		createVersionTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER NOT NULL PRIMARY KEY,
+			name TEXT NOT NULL,
+			dirty BOOLEAN NOT NULL DEFAULT FALSE,
+			applied_at DATETIME NOT NULL)`,
+		markDirty: "INSERT INTO schema_migrations (version, name, dirty, applied_at) VALUES (?, ?, TRUE, ?) " +
+			"ON CONFLICT (version) DO UPDATE SET dirty = TRUE",
+		markClean:     "UPDATE schema_migrations SET dirty = FALSE WHERE version = ?",
+		deleteVersion: "DELETE FROM schema_migrations WHERE version = ?",
+		// a sqlite file has a single writer and the pool holds one connection, nothing to lock
+		lock:   func(context.Context, *sql.Conn, time.Duration) error { return nil },
+		unlock: func(*sql.Conn) {},
+	},
+}
+
+func dialectFor(driver string) (dialect, error) {
+	d, ok := dialects[driver]
+	if !ok {
+		return dialect{}, fmt.Errorf("no migrations for driver %q", driver)
+	}
+	return d, nil
+}
@@ -0,0 +1,226 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrSchemaOutdated is returned by Check when migrations are pending.
+var ErrSchemaOutdated = errors.New("database schema is outdated, run: ewallet migrate up")
+
+var errLockTimeout = errors.New("timed out")
+
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+	// LockTimeout is how long to wait for another process holding the migration lock
+	LockTimeout time.Duration
+	Logger      *slog.Logger
+
+	dialect dialect
+}
+
+// NewMigrator uses the embedded migrations of driver, one of the helpers.DBDriver* values.
+func NewMigrator(db *sql.DB, driver string, logger *slog.Logger) (*Migrator, error) {
+	d, err := dialectFor(driver)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := Load(driver)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations, LockTimeout: time.Minute, Logger: logger, dialect: d}, nil
+}
+
+type Status struct {
+	Migration
+	Applied   bool
+	Dirty     bool
+	AppliedAt *time.Time
+}
+
+type appliedVersion struct {
+	dirty     bool
+	appliedAt time.Time
+}
+
+// Up applies every pending migration in order and returns how many were applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := checkDirty(applied); err != nil {
+			return err
+		}
+		for _, migration := range m.Migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := m.run(ctx, conn, migration, migration.Up); err != nil {
+				return err
+			}
+			_, err := conn.ExecContext(ctx, m.dialect.markClean, migration.Version)
+			if err != nil {
+				return err
+			}
+			m.Logger.Info("migration applied", "version", migration.Version, "name", migration.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Down reverts the last steps applied migrations, newest first.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := checkDirty(applied); err != nil {
+			return err
+		}
+		for i := len(m.Migrations) - 1; i >= 0 && count < steps; i-- {
+			migration := m.Migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if err := m.run(ctx, conn, migration, migration.Down); err != nil {
+				return err
+			}
+			_, err := conn.ExecContext(ctx, m.dialect.deleteVersion, migration.Version)
+			if err != nil {
+				return err
+			}
+			m.Logger.Info("migration reverted", "version", migration.Version, "name", migration.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Status lists every known migration and whether it is applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(m.Migrations))
+	for _, migration := range m.Migrations {
+		status := Status{Migration: migration}
+		if version, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.Dirty = version.dirty
+			status.AppliedAt = &version.appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Check fails unless every migration is applied cleanly, the api and worker call it at startup.
+func (m *Migrator) Check(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.Dirty {
+			return dirtyError(status.Version)
+		}
+		if !status.Applied {
+			return fmt.Errorf("%w, migration %d_%s is pending", ErrSchemaOutdated, status.Version, status.Name)
+		}
+	}
+	return nil
+}
+
+// run executes a migration script. mysql commits DDL implicitly, so the version row is
+// written as dirty first and a failure halfway leaves it dirty for a human to look at.
+// postgres and sqlite behave the same way to keep one code path.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, migration Migration, script string) error {
+	_, err := conn.ExecContext(ctx, m.dialect.markDirty, migration.Version, migration.Name, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	for _, stmt := range statements(script) {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]appliedVersion, error) {
+	if _, err := conn.ExecContext(ctx, m.dialect.createVersionTable); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT version, dirty, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]appliedVersion{}
+	for rows.Next() {
+		var version int64
+		var v appliedVersion
+		if err := rows.Scan(&version, &v.dirty, &v.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = v
+	}
+	return applied, rows.Err()
+}
+
+// withLock holds a session lock on one connection, so an api and a worker starting
+// together, or two migrate jobs, never run migrations at the same time.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = m.dialect.lock(ctx, conn, m.LockTimeout)
+	if errors.Is(err, errLockTimeout) {
+		return fmt.Errorf("migration lock is held by another process, waited %s", m.LockTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to take migration lock: %w", err)
+	}
+	defer m.dialect.unlock(conn)
+
+	return fn(conn)
+}
+
+func checkDirty(applied map[int64]appliedVersion) error {
+	for version, v := range applied {
+		if v.dirty {
+			return dirtyError(version)
+		}
+	}
+	return nil
+}
+
+func dirtyError(version int64) error {
+	return fmt.Errorf("migration %d failed halfway, fix the schema by hand and then delete its row from schema_migrations "+
+		"(if it was reverted) or set dirty = FALSE (if it was completed)", version)
+}
@@ -0,0 +1,17 @@
+-- baseline, the table as gorm automigrate created it. IF NOT EXISTS adopts databases
+-- that were set up before versioned migrations.
+CREATE TABLE IF NOT EXISTS `transaction` (
+    `id` BIGINT NOT NULL AUTO_INCREMENT,
+    `user_id` BIGINT,
+    `amount` DOUBLE,
+    `type` LONGTEXT,
+    `status` LONGTEXT,
+    `reference` LONGTEXT,
+    `description` LONGTEXT,
+    `additional_info` LONGTEXT,
+    `balance_after` DOUBLE,
+    `hold_id` LONGTEXT,
+    `created_at` DATETIME(3),
+    `updated_at` DATETIME(3),
+    PRIMARY KEY (`id`)
+);
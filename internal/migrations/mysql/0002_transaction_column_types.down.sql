@@ -0,0 +1,13 @@
+-- the dropped token column is not restored, its values are gone
+ALTER TABLE `transaction`
+    MODIFY `user_id` BIGINT,
+    MODIFY `amount` DOUBLE,
+    MODIFY `type` LONGTEXT,
+    MODIFY `status` LONGTEXT,
+    MODIFY `reference` LONGTEXT,
+    MODIFY `description` LONGTEXT,
+    MODIFY `additional_info` LONGTEXT,
+    MODIFY `balance_after` DOUBLE,
+    MODIFY `hold_id` LONGTEXT,
+    MODIFY `created_at` DATETIME(3),
+    MODIFY `updated_at` DATETIME(3);
@@ -0,0 +1,23 @@
+-- the token column held user bearer tokens before they were moved out of the table,
+-- only databases created back then have it
+SET @drop_token = (
+    SELECT IF(COUNT(*) > 0, 'ALTER TABLE `transaction` DROP COLUMN `token`', 'DO 0')
+    FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'transaction' AND column_name = 'token'
+);
+PREPARE drop_token FROM @drop_token;
+EXECUTE drop_token;
+DEALLOCATE PREPARE drop_token;
+
+ALTER TABLE `transaction`
+    MODIFY `user_id` BIGINT NOT NULL,
+    MODIFY `amount` DECIMAL(18,2) NOT NULL,
+    MODIFY `type` VARCHAR(16) NOT NULL,
+    MODIFY `status` VARCHAR(16) NOT NULL,
+    MODIFY `reference` VARCHAR(64) NOT NULL,
+    MODIFY `description` VARCHAR(255) NOT NULL DEFAULT '',
+    MODIFY `additional_info` TEXT NULL,
+    MODIFY `balance_after` DECIMAL(18,2) NULL,
+    MODIFY `hold_id` VARCHAR(64) NULL,
+    MODIFY `created_at` DATETIME(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    MODIFY `updated_at` DATETIME(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3);
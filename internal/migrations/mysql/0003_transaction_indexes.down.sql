@@ -0,0 +1,6 @@
+ALTER TABLE `transaction`
+    DROP INDEX `uq_transaction_reference`,
+    DROP INDEX `idx_transaction_user_created`,
+    DROP INDEX `idx_transaction_user_status_created`,
+    DROP INDEX `idx_transaction_status_created`,
+    DROP INDEX `idx_transaction_created`;
@@ -0,0 +1,9 @@
+-- fails while duplicate references exist, they have to be resolved by hand first
+ALTER TABLE `transaction`
+    ADD UNIQUE INDEX `uq_transaction_reference` (`reference`),
+    -- user history, newest first, optionally filtered by status
+    ADD INDEX `idx_transaction_user_created` (`user_id`, `created_at`),
+    ADD INDEX `idx_transaction_user_status_created` (`user_id`, `status`, `created_at`),
+    -- sweep-stuck and reconcile
+    ADD INDEX `idx_transaction_status_created` (`status`, `created_at`),
+    ADD INDEX `idx_transaction_created` (`created_at`);
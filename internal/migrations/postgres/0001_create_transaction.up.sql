@@ -0,0 +1,15 @@
+-- postgres starts from the final column types, mysql gets there in 0002
+CREATE TABLE IF NOT EXISTS "transaction" (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    amount NUMERIC(18,2) NOT NULL,
+    type VARCHAR(16) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    reference VARCHAR(64) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    additional_info TEXT NULL,
+    balance_after NUMERIC(18,2) NULL,
+    hold_id VARCHAR(64) NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1 @@
+-- nothing to do, see the up migration
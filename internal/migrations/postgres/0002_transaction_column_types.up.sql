@@ -0,0 +1,2 @@
+-- nothing to do, 0001 already creates the final column types on postgres.
+-- the file keeps migration versions the same across drivers.
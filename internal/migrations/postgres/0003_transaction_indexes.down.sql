@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS uq_transaction_reference;
+DROP INDEX IF EXISTS idx_transaction_user_created;
+DROP INDEX IF EXISTS idx_transaction_user_status_created;
+DROP INDEX IF EXISTS idx_transaction_status_created;
+DROP INDEX IF EXISTS idx_transaction_created;
@@ -0,0 +1,15 @@
+-- sqlite starts from the final column types, mysql gets there in 0002
+CREATE TABLE IF NOT EXISTS "transaction" (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    amount NUMERIC NOT NULL,
+    type TEXT NOT NULL,
+    status TEXT NOT NULL,
+    reference TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    additional_info TEXT NULL,
+    balance_after NUMERIC NULL,
+    hold_id TEXT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,7 @@
+CREATE UNIQUE INDEX uq_transaction_reference ON "transaction" (reference);
+-- user history, newest first, optionally filtered by status
+CREATE INDEX idx_transaction_user_created ON "transaction" (user_id, created_at);
+CREATE INDEX idx_transaction_user_status_created ON "transaction" (user_id, status, created_at);
+-- sweep-stuck and reconcile
+CREATE INDEX idx_transaction_status_created ON "transaction" (status, created_at);
+CREATE INDEX idx_transaction_created ON "transaction" (created_at);
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+const (
+	HealthStatusUp       = "up"
+	HealthStatusDown     = "down"
+	HealthStatusOK       = "ok"
+	HealthStatusDegraded = "degraded"
+	// HealthStatusDraining is reported by readiness once shutdown started
+	HealthStatusDraining = "draining"
+)
+
+type ComponentHealth struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Critical  bool      `json:"critical"`
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// DBPoolStats is a snapshot of one database connection pool.
+type DBPoolStats struct {
+	Name              string `json:"name"`
+	MaxOpen           int    `json:"max_open"`
+	Open              int    `json:"open"`
+	InUse             int    `json:"in_use"`
+	Idle              int    `json:"idle"`
+	WaitCount         int64  `json:"wait_count"`
+	WaitDurationMs    int64  `json:"wait_duration_ms"`
+	MaxIdleClosed     int64  `json:"max_idle_closed"`
+	MaxLifetimeClosed int64  `json:"max_lifetime_closed"`
+}
+
+type HealthReport struct {
+	Status     string            `json:"status"`
+	Ready      bool              `json:"ready"`
+	Components []ComponentHealth `json:"components"`
+	DBPools    []DBPoolStats     `json:"db_pools,omitempty"`
+	CheckedAt  time.Time         `json:"checked_at"`
+}
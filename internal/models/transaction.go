@@ -24,8 +24,9 @@ type Transaction struct {
 	Status         TransactionStatus
 	Reference      string
 	Description    string
-	Token          string
 	AdditionalInfo *string
+	BalanceAfter   *float64
+	HoldID         *string
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
@@ -42,7 +43,8 @@ type CreateTransactionRequest struct {
 	Amount      int64  `json:"amount" validate:"required,gt=0"`
 	Type        string `json:"transaction_type" validate:"required,oneof=TOPUP PURCHASE"`
 	Description string `json:"description" validate:"required"`
-	Token       string `json:"token"`
+	// User is filled from the validated token, never from the request body
+	User TokenData `json:"user"`
 }
 
 // Status Update DTO
@@ -51,3 +53,29 @@ type UpdateTransactionStatus struct {
 	Status    string  `json:"status" validate:"required,oneof=CONFIRM CANCEL"`
 	Reason    *string `json:"reason,omitempty"`
 }
+
+// TransactionStateResponse is the live state of a transaction workflow.
+type TransactionStateResponse struct {
+	Reference string            `json:"reference"`
+	Type      TransactionType   `json:"transaction_type"`
+	Status    TransactionStatus `json:"status"`
+	Step      string            `json:"step"`
+	Reason    *string           `json:"reason"`
+	Steps     []TransactionStep `json:"steps"`
+	LastError *string           `json:"last_error"`
+	Deadline  *time.Time        `json:"deadline"`
+	// PendingActivities are the activities running or waiting for a retry, with their attempt
+	PendingActivities []PendingActivity `json:"pending_activities"`
+}
+
+type TransactionStep struct {
+	Step string    `json:"step"`
+	At   time.Time `json:"at"`
+}
+
+type PendingActivity struct {
+	Activity  string  `json:"activity"`
+	State     string  `json:"state"`
+	Attempt   int32   `json:"attempt"`
+	LastError *string `json:"last_error"`
+}
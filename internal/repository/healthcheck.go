@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"context"
+	"ewallet-topup/helpers"
+	"ewallet-topup/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type HealthcheckRepo struct {
+	DB *gorm.DB
+	// Pools are reported in readiness, the primary and every replica
+	Pools helpers.DBPools
+}
+
+// Ping checks the primary, replica outages show up as failing reads and in the pool stats.
+func (r *HealthcheckRepo) Ping(ctx context.Context) error {
+	sqlDB, err := r.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func (r *HealthcheckRepo) PoolStats() []models.DBPoolStats {
+	stats := make([]models.DBPoolStats, 0, len(r.Pools))
+	for _, pool := range r.Pools {
+		s := pool.DB.Stats()
+		stats = append(stats, models.DBPoolStats{
+			Name:              pool.Name,
+			MaxOpen:           s.MaxOpenConnections,
+			Open:              s.OpenConnections,
+			InUse:             s.InUse,
+			Idle:              s.Idle,
+			WaitCount:         s.WaitCount,
+			WaitDurationMs:    s.WaitDuration.Milliseconds(),
+			MaxIdleClosed:     s.MaxIdleClosed,
+			MaxLifetimeClosed: s.MaxLifetimeClosed,
+		})
+	}
+	return stats
+}
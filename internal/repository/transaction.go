@@ -2,12 +2,17 @@ package repository
 
 import (
 	"context"
+	"ewallet-topup/internal/interfaces"
 	"ewallet-topup/internal/models"
+	"time"
 
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
 )
 
+// TransactionRepo reads from the replicas when DB_REPLICA_HOSTS is set, except where a
+// method pins the primary with dbresolver.Write. Writes and InTx always use the primary.
 type TransactionRepo struct {
 	DB *gorm.DB
 }
@@ -16,22 +21,65 @@ func (r *TransactionRepo) Create(ctx context.Context, trx *models.Transaction) e
 	return r.DB.WithContext(ctx).Create(trx).Error
 }
 
+// CreateIfNotExists inserts trx unless its reference exists already, reporting whether it did.
+// gorm renders the conflict clause per dialect: ON DUPLICATE KEY on mysql, ON CONFLICT elsewhere.
+func (r *TransactionRepo) CreateIfNotExists(ctx context.Context, trx *models.Transaction) (bool, error) {
+	result := r.DB.WithContext(ctx).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "reference"}}, DoNothing: true}).
+		Create(trx)
+	return result.RowsAffected > 0, result.Error
+}
+
+// InTx runs fn with a repo bound to one database transaction, committed when fn returns nil.
+func (r *TransactionRepo) InTx(ctx context.Context, fn func(repo interfaces.ITransactionRepo) error) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&TransactionRepo{DB: tx})
+	})
+}
+
+// FindByReference reads the primary, activities call it right after writing the row and
+// must not see a lagging replica.
 func (r *TransactionRepo) FindByReference(ctx context.Context, ref string) (*models.Transaction, error) {
 
 	var trx models.Transaction
-	err := r.DB.WithContext(ctx).Where("reference = ?", ref).First(&trx).Error
+	err := r.DB.WithContext(ctx).Clauses(dbresolver.Write).Where("reference = ?", ref).First(&trx).Error
 
 	return &trx, err
 }
 
+// FindByReferenceForUpdate locks the row until the surrounding InTx ends. The sqlite dialect
+// drops the locking clause, its write transactions are serialized as a whole instead.
 func (r *TransactionRepo) FindByReferenceForUpdate(ctx context.Context, ref string) (*models.Transaction, error) {
 
 	var trx models.Transaction
-	err := r.DB.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Where("reference = ?", ref).First(&trx).Error
+	err := r.DB.WithContext(ctx).Clauses(dbresolver.Write, clause.Locking{Strength: "UPDATE"}).Where("reference = ?", ref).First(&trx).Error
 
 	return &trx, err
 }
 
+// FindPendingCreatedBefore returns the oldest PENDING transactions created before the given time.
+// It reads a replica, the sweep checks each workflow before failing anything.
+func (r *TransactionRepo) FindPendingCreatedBefore(ctx context.Context, before time.Time, limit int) ([]models.Transaction, error) {
+	var trxs []models.Transaction
+	err := r.DB.WithContext(ctx).
+		Where("status = ? AND created_at < ?", models.TransactionStatusPending, before).
+		Order("created_at").
+		Limit(limit).
+		Find(&trxs).Error
+	return trxs, err
+}
+
+// FindCreatedSince feeds the reconcile report and reads a replica.
+func (r *TransactionRepo) FindCreatedSince(ctx context.Context, since time.Time, limit int) ([]models.Transaction, error) {
+	var trxs []models.Transaction
+	err := r.DB.WithContext(ctx).
+		Where("created_at >= ?", since).
+		Order("created_at").
+		Limit(limit).
+		Find(&trxs).Error
+	return trxs, err
+}
+
 func (r *TransactionRepo) UpdateStatus(ctx context.Context, reference string, status models.TransactionStatus, reason *string) error {
 
 	updateData := map[string]interface{}{
@@ -48,3 +96,19 @@ func (r *TransactionRepo) UpdateStatus(ctx context.Context, reference string, st
 		Updates(updateData).
 		Error
 }
+
+func (r *TransactionRepo) UpdateHoldID(ctx context.Context, reference string, holdID string) error {
+	return r.DB.WithContext(ctx).
+		Model(&models.Transaction{}).
+		Where("reference = ?", reference).
+		Update("hold_id", holdID).
+		Error
+}
+
+func (r *TransactionRepo) UpdateBalanceAfter(ctx context.Context, reference string, balance float64) error {
+	return r.DB.WithContext(ctx).
+		Model(&models.Transaction{}).
+		Where("reference = ?", reference).
+		Update("balance_after", balance).
+		Error
+}
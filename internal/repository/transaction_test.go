@@ -0,0 +1,202 @@
+//go:build cgo
+
+package repository_test
+
+import (
+	"context"
+	"ewallet-topup/internal/fakes"
+	"ewallet-topup/internal/interfaces"
+	"ewallet-topup/internal/models"
+	"ewallet-topup/internal/repository"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+func newTestRepo(t *testing.T) *repository.TransactionRepo {
+	t.Helper()
+	db, pools, err := fakes.OpenSQLite(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	t.Cleanup(func() { _ = pools.Close() })
+	return &repository.TransactionRepo{DB: db}
+}
+
+func pending(ref string) *models.Transaction {
+	return &models.Transaction{
+		UserID:      7,
+		Amount:      150,
+		Type:        models.TransactionTypePurchase,
+		Status:      models.TransactionStatusPending,
+		Reference:   ref,
+		Description: "pulsa",
+	}
+}
+
+func TestCreateIfNotExists(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	created, err := repo.CreateIfNotExists(ctx, pending("ref-1"))
+	if err != nil || !created {
+		t.Fatalf("first insert = %v, %v, want created", created, err)
+	}
+	again := pending("ref-1")
+	again.Amount = 999
+	created, err = repo.CreateIfNotExists(ctx, again)
+	if err != nil || created {
+		t.Fatalf("second insert = %v, %v, want skipped", created, err)
+	}
+
+	trx, err := repo.FindByReference(ctx, "ref-1")
+	if err != nil {
+		t.Fatalf("FindByReference: %v", err)
+	}
+	if trx.Amount != 150 {
+		t.Errorf("amount = %v, the retry overwrote the row", trx.Amount)
+	}
+	if err := repo.Create(ctx, pending("ref-1")); err == nil {
+		t.Error("Create accepted a duplicate reference")
+	}
+}
+
+func TestFindByReferenceMissing(t *testing.T) {
+	repo := newTestRepo(t)
+	if _, err := repo.FindByReference(context.Background(), "nope"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("error = %v, want record not found", err)
+	}
+}
+
+func TestUpdates(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	if err := repo.Create(ctx, pending("ref-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	reason := "saldo tidak cukup"
+	if err := repo.UpdateStatus(ctx, "ref-1", models.TransactionStatusFailed, &reason); err != nil {
+		t.Fatalf("UpdateStatus: %v", err)
+	}
+	if err := repo.UpdateHoldID(ctx, "ref-1", "hold-1"); err != nil {
+		t.Fatalf("UpdateHoldID: %v", err)
+	}
+	if err := repo.UpdateBalanceAfter(ctx, "ref-1", 850); err != nil {
+		t.Fatalf("UpdateBalanceAfter: %v", err)
+	}
+
+	trx, err := repo.FindByReference(ctx, "ref-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trx.Status != models.TransactionStatusFailed ||
+		trx.AdditionalInfo == nil || *trx.AdditionalInfo != reason ||
+		trx.HoldID == nil || *trx.HoldID != "hold-1" ||
+		trx.BalanceAfter == nil || *trx.BalanceAfter != 850 {
+		t.Errorf("transaction = %+v", trx)
+	}
+
+	// no reason keeps the previous one
+	if err := repo.UpdateStatus(ctx, "ref-1", models.TransactionStatusReversed, nil); err != nil {
+		t.Fatal(err)
+	}
+	trx, _ = repo.FindByReference(ctx, "ref-1")
+	if trx.AdditionalInfo == nil || *trx.AdditionalInfo != reason {
+		t.Errorf("additional info = %v, want %q", trx.AdditionalInfo, reason)
+	}
+}
+
+func TestInTx(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	if err := repo.Create(ctx, pending("ref-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	rollback := errors.New("rollback")
+	err := repo.InTx(ctx, func(tx interfaces.ITransactionRepo) error {
+		if _, err := tx.FindByReferenceForUpdate(ctx, "ref-1"); err != nil {
+			return err
+		}
+		if err := tx.UpdateStatus(ctx, "ref-1", models.TransactionStatusSuccess, nil); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("InTx error = %v, want %v", err, rollback)
+	}
+	trx, _ := repo.FindByReference(ctx, "ref-1")
+	if trx.Status != models.TransactionStatusPending {
+		t.Errorf("status = %s after rollback, want PENDING", trx.Status)
+	}
+
+	err = repo.InTx(ctx, func(tx interfaces.ITransactionRepo) error {
+		return tx.UpdateStatus(ctx, "ref-1", models.TransactionStatusSuccess, nil)
+	})
+	if err != nil {
+		t.Fatalf("InTx: %v", err)
+	}
+	trx, _ = repo.FindByReference(ctx, "ref-1")
+	if trx.Status != models.TransactionStatusSuccess {
+		t.Errorf("status = %s after commit, want SUCCESS", trx.Status)
+	}
+}
+
+func TestFindByCreatedAt(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	rows := []struct {
+		ref    string
+		age    time.Duration
+		status models.TransactionStatus
+	}{
+		{"ref-new", time.Minute, models.TransactionStatusPending},
+		{"ref-old", 3 * time.Hour, models.TransactionStatusPending},
+		{"ref-older", 4 * time.Hour, models.TransactionStatusPending},
+		{"ref-old-done", 5 * time.Hour, models.TransactionStatusSuccess},
+		{"ref-ancient", 48 * time.Hour, models.TransactionStatusPending},
+	}
+	for _, row := range rows {
+		trx := pending(row.ref)
+		trx.Status = row.status
+		trx.CreatedAt = now.Add(-row.age)
+		if err := repo.Create(ctx, trx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stuck, err := repo.FindPendingCreatedBefore(ctx, now.Add(-time.Hour), 2)
+	if err != nil {
+		t.Fatalf("FindPendingCreatedBefore: %v", err)
+	}
+	if got := references(stuck); got != "ref-ancient,ref-older" {
+		t.Errorf("pending before = %s, want the two oldest pending", got)
+	}
+
+	recent, err := repo.FindCreatedSince(ctx, now.Add(-24*time.Hour), 10)
+	if err != nil {
+		t.Fatalf("FindCreatedSince: %v", err)
+	}
+	if got := references(recent); got != "ref-old-done,ref-older,ref-old,ref-new" {
+		t.Errorf("created since = %s", got)
+	}
+}
+
+func references(trxs []models.Transaction) string {
+	var out string
+	for i, trx := range trxs {
+		if i > 0 {
+			out += ","
+		}
+		out += trx.Reference
+	}
+	return out
+}
@@ -1,11 +1,192 @@
 package services
 
-import "ewallet-topup/internal/interfaces"
+import (
+	"context"
+	"ewallet-topup/external"
+	"ewallet-topup/helpers"
+	"ewallet-topup/internal/interfaces"
+	"ewallet-topup/internal/models"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.temporal.io/sdk/client"
+)
+
+type HealthCheck struct {
+	Name     string
+	Critical bool
+	Timeout  time.Duration
+	Check    func(ctx context.Context) error
+}
+
+type HealthConfig struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	// UMSCritical marks ums as required for readiness, true when tokens are validated through it
+	UMSCritical bool
+}
+
+func LoadHealthConfig(env *helpers.Env) HealthConfig {
+	return HealthConfig{
+		Interval:    env.GetDuration("HEALTH_CHECK_INTERVAL", 10*time.Second),
+		Timeout:     env.GetDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+		UMSCritical: true,
+	}
+}
 
 type Healthcheck struct {
 	HealthcheckRepository interfaces.IHealthcheckRepo
+	External              interfaces.IExternal
+	Checks                []HealthCheck
+	Interval              time.Duration
+
+	mu       sync.RWMutex
+	report   *models.HealthReport
+	draining atomic.Bool
+}
+
+// NewHealthcheck registers the readiness checks. the database and temporal are critical,
+// ums is critical only when tokens are validated through it.
+func NewHealthcheck(repo interfaces.IHealthcheckRepo, ext interfaces.IExternal, temporal client.Client, cfg HealthConfig) *Healthcheck {
+	timeout := cfg.Timeout
+
+	s := &Healthcheck{
+		HealthcheckRepository: repo,
+		External:              ext,
+		Interval:              cfg.Interval,
+	}
+	if repo != nil {
+		s.Checks = append(s.Checks, HealthCheck{Name: "database", Critical: true, Timeout: timeout, Check: repo.Ping})
+	}
+	if temporal != nil {
+		s.Checks = append(s.Checks, HealthCheck{Name: "temporal", Critical: true, Timeout: timeout, Check: func(ctx context.Context) error {
+			_, err := temporal.CheckHealth(ctx, &client.CheckHealthRequest{})
+			return err
+		}})
+	}
+	if ext != nil {
+		s.Checks = append(s.Checks,
+			HealthCheck{Name: "ums", Critical: cfg.UMSCritical, Timeout: timeout, Check: ext.PingUMS},
+			HealthCheck{Name: "wallet", Critical: false, Timeout: timeout, Check: ext.PingWallet},
+			HealthCheck{Name: "notification", Critical: false, Timeout: timeout, Check: ext.PingNotification},
+		)
+	}
+	return s
+}
+
+// Start refreshes the cached report in the background so probes never hit dependencies directly.
+func (s *Healthcheck) Start(ctx context.Context) {
+	s.refresh(ctx)
+
+	go func() {
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.refresh(ctx)
+			}
+		}
+	}()
 }
 
 func (s *Healthcheck) HealthcheckServices() (string, error) {
+	for _, breaker := range s.CircuitBreakers() {
+		if breaker.State == external.BreakerOpen {
+			return "service degraded", nil
+		}
+	}
 	return "service healthy", nil
 }
+
+func (s *Healthcheck) CircuitBreakers() []external.BreakerSnapshot {
+	if s.External == nil {
+		return nil
+	}
+	return s.External.BreakerStates()
+}
+
+// MarkDraining makes readiness fail from now on, called when shutdown starts so the
+// load balancer stops routing new requests while in-flight ones finish.
+func (s *Healthcheck) MarkDraining() {
+	s.draining.Store(true)
+}
+
+func (s *Healthcheck) Readiness(ctx context.Context) models.HealthReport {
+	s.mu.RLock()
+	report := s.report
+	s.mu.RUnlock()
+	if report == nil {
+		r := s.refresh(ctx)
+		report = &r
+	}
+	if s.draining.Load() {
+		draining := *report
+		draining.Status = models.HealthStatusDraining
+		draining.Ready = false
+		return draining
+	}
+	return *report
+}
+
+func (s *Healthcheck) refresh(ctx context.Context) models.HealthReport {
+	components := make([]models.ComponentHealth, len(s.Checks))
+
+	var wg sync.WaitGroup
+	for i, check := range s.Checks {
+		wg.Add(1)
+		go func(i int, check HealthCheck) {
+			defer wg.Done()
+			components[i] = runHealthCheck(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := models.HealthReport{
+		Status:     models.HealthStatusOK,
+		Ready:      true,
+		Components: components,
+		CheckedAt:  time.Now(),
+	}
+	if s.HealthcheckRepository != nil {
+		report.DBPools = s.HealthcheckRepository.PoolStats()
+	}
+	for _, component := range components {
+		if component.Status == models.HealthStatusUp {
+			continue
+		}
+		report.Status = models.HealthStatusDegraded
+		if component.Critical {
+			report.Status = models.HealthStatusDown
+			report.Ready = false
+		}
+	}
+
+	s.mu.Lock()
+	s.report = &report
+	s.mu.Unlock()
+	return report
+}
+
+func runHealthCheck(ctx context.Context, check HealthCheck) models.ComponentHealth {
+	ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Check(ctx)
+	result := models.ComponentHealth{
+		Name:      check.Name,
+		Status:    models.HealthStatusUp,
+		Critical:  check.Critical,
+		LatencyMs: time.Since(start).Milliseconds(),
+		CheckedAt: time.Now(),
+	}
+	if err != nil {
+		result.Status = models.HealthStatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"ewallet-topup/helpers"
+	"ewallet-topup/internal/interfaces"
+	"ewallet-topup/internal/models"
+	"ewallet-topup/internal/workflow"
+	"ewallet-topup/internal/workflow/transaction"
+	"log/slog"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+)
+
+// workflowNotFound is reported next to the temporal statuses (Running, Completed, Failed, ...)
+const workflowNotFound = "NotFound"
+
+// MaintenanceService backs the reconcile and sweep-stuck admin commands. It compares
+// transaction rows with the state of their temporal workflow.
+type MaintenanceService struct {
+	TransactionRepo    interfaces.ITransactionRepo
+	TransactionService interfaces.ITransactionService
+	Temporal           client.Client
+	Logger             *slog.Logger
+}
+
+// steps after which the wallet already moved money, or may have when a credit or capture
+// failed without a known outcome, e.g. a timeout. A sweep must never fail those transactions
+var moneyMovedSteps = map[string]bool{
+	"CONFIRMED":                true,
+	"CREDIT_FAILED":            true,
+	"CAPTURE_FAILED":           true,
+	"UPDATE_STATUS_FAILED":     true,
+	"SUCCESS":                  true,
+	"SEND_NOTIFICATION_FAILED": true,
+}
+
+type SweepResult struct {
+	Checked int
+	Swept   int
+	// Review lists references that are stuck but need a human, e.g. the wallet was already charged
+	Review []string
+}
+
+// SweepStuck fails PENDING transactions older than olderThan whose workflow closed before
+// moving money, and releases their wallet hold. Running workflows are left alone, their own
+// expiry timer takes care of them. A missing workflow goes to review: it was most likely
+// deleted after the namespace retention, so nothing tells whether the wallet was charged.
+func (s *MaintenanceService) SweepStuck(ctx context.Context, olderThan time.Duration, limit int, dryRun bool) (SweepResult, error) {
+	var result SweepResult
+	trxs, err := s.TransactionRepo.FindPendingCreatedBefore(ctx, time.Now().Add(-olderThan), limit)
+	if err != nil {
+		return result, errors.Wrap(err, "failed to list pending transactions")
+	}
+
+	for i := range trxs {
+		trx := &trxs[i]
+		result.Checked++
+		ctx := helpers.WithLogFields(ctx, "reference", trx.Reference)
+
+		status, err := s.workflowStatus(ctx, trx.Reference)
+		if err != nil {
+			return result, err
+		}
+		if status == enums.WORKFLOW_EXECUTION_STATUS_RUNNING.String() {
+			continue
+		}
+
+		if status == workflowNotFound {
+			s.Logger.WarnContext(ctx, "stuck transaction has no workflow, needs manual review", "created_at", trx.CreatedAt)
+			result.Review = append(result.Review, trx.Reference)
+			continue
+		}
+		state, err := s.queryState(ctx, trx.Reference)
+		if err != nil || moneyMovedSteps[state.Step] {
+			s.Logger.WarnContext(ctx, "stuck transaction needs manual review", "workflow_status", status, "step", state.Step, "error", err)
+			result.Review = append(result.Review, trx.Reference)
+			continue
+		}
+		reason := "swept: workflow " + status + " at " + state.Step
+
+		if dryRun {
+			s.Logger.InfoContext(ctx, "would sweep stuck transaction", "reason", reason)
+			result.Swept++
+			continue
+		}
+		if err := s.TransactionService.VoidHold(ctx, trx); err != nil {
+			s.Logger.WarnContext(ctx, "failed to void hold of stuck transaction", "error", err)
+			result.Review = append(result.Review, trx.Reference)
+			continue
+		}
+		if err := s.TransactionService.UpdateStatus(ctx, trx.Reference, models.TransactionStatusFailed, &reason); err != nil {
+			return result, errors.Wrapf(err, "failed to fail transaction %s", trx.Reference)
+		}
+		s.Logger.InfoContext(ctx, "swept stuck transaction", "reason", reason)
+		result.Swept++
+	}
+	return result, nil
+}
+
+type ReconcileMismatch struct {
+	Reference      string
+	Status         models.TransactionStatus
+	WorkflowStatus string
+	Problem        string
+}
+
+// Reconcile reports transactions created within since whose row disagrees with their workflow
+// or breaks an invariant. It never changes anything.
+func (s *MaintenanceService) Reconcile(ctx context.Context, since time.Duration, limit int) ([]ReconcileMismatch, int, error) {
+	trxs, err := s.TransactionRepo.FindCreatedSince(ctx, time.Now().Add(-since), limit)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to list transactions")
+	}
+
+	mismatches := []ReconcileMismatch{}
+	for _, trx := range trxs {
+		status, err := s.workflowStatus(ctx, trx.Reference)
+		if err != nil {
+			return nil, 0, err
+		}
+		mismatch := ReconcileMismatch{Reference: trx.Reference, Status: trx.Status, WorkflowStatus: status}
+
+		switch {
+		case status == workflowNotFound:
+			mismatch.Problem = "transaction has no workflow"
+		case status != enums.WORKFLOW_EXECUTION_STATUS_RUNNING.String() && trx.Status == models.TransactionStatusPending:
+			mismatch.Problem = "workflow closed but transaction is still pending"
+		case trx.Status == models.TransactionStatusSuccess && trx.BalanceAfter == nil:
+			mismatch.Problem = "successful transaction has no balance_after"
+		case trx.Type == models.TransactionTypePurchase && trx.Status == models.TransactionStatusSuccess && trx.HoldID == nil:
+			mismatch.Problem = "successful purchase has no wallet hold"
+		default:
+			continue
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+	return mismatches, len(trxs), nil
+}
+
+func (s *MaintenanceService) workflowStatus(ctx context.Context, reference string) (string, error) {
+	resp, err := s.Temporal.DescribeWorkflowExecution(ctx, workflow.TransactionWorkflowID(reference), "")
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		return workflowNotFound, nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to describe workflow of %s", reference)
+	}
+	return resp.GetWorkflowExecutionInfo().GetStatus().String(), nil
+}
+
+// queryState works on closed workflows too, as long as a worker polls the task queue.
+func (s *MaintenanceService) queryState(ctx context.Context, reference string) (transaction.TransactionState, error) {
+	var state transaction.TransactionState
+	resp, err := s.Temporal.QueryWorkflow(ctx, workflow.TransactionWorkflowID(reference), "", transaction.QueryTransactionState)
+	if err != nil {
+		return state, err
+	}
+	err = resp.Get(&state)
+	return state, err
+}
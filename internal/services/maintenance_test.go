@@ -0,0 +1,112 @@
+package services_test
+
+import (
+	"context"
+	"ewallet-topup/internal/fakes"
+	"ewallet-topup/internal/models"
+	"ewallet-topup/internal/services"
+	"ewallet-topup/internal/workflow"
+	"ewallet-topup/internal/workflow/transaction"
+	"io"
+	"log/slog"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	enumspb "go.temporal.io/api/enums/v1"
+)
+
+func TestSweepStuck(t *testing.T) {
+	ctx := context.Background()
+	repo := fakes.NewTransactionRepo()
+	repo.Now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+	trxService := fakes.NewTransactionService()
+	temporal := fakes.NewTemporal()
+
+	// reference -> step the closed workflow stopped at, empty when the workflow is still running
+	closedAt := map[string]string{
+		"ref-running":        "",
+		"ref-create-failed":  "CREATE_PENDING_FAILED",
+		"ref-credit-failed":  "CREDIT_FAILED",
+		"ref-capture-failed": "CAPTURE_FAILED",
+		"ref-status-failed":  "UPDATE_STATUS_FAILED",
+	}
+	for ref, step := range closedAt {
+		id := workflow.TransactionWorkflowID(ref)
+		temporal.Results[id] = transaction.TransactionState{Reference: ref, Step: step}
+		if step != "" {
+			temporal.Statuses[id] = enumspb.WORKFLOW_EXECUTION_STATUS_FAILED
+		}
+	}
+	for _, ref := range []string{"ref-running", "ref-create-failed", "ref-credit-failed", "ref-capture-failed", "ref-status-failed", "ref-no-workflow"} {
+		if _, err := repo.CreateIfNotExists(ctx, &models.Transaction{Reference: ref, Status: models.TransactionStatusPending}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	svc := &services.MaintenanceService{
+		TransactionRepo:    repo,
+		TransactionService: trxService,
+		Temporal:           temporal,
+		Logger:             slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	result, err := svc.SweepStuck(ctx, time.Hour, 100, false)
+	if err != nil {
+		t.Fatalf("SweepStuck: %v", err)
+	}
+
+	if result.Checked != 6 || result.Swept != 1 {
+		t.Errorf("checked %d swept %d, want 6 and 1", result.Checked, result.Swept)
+	}
+	sort.Strings(result.Review)
+	wantReview := []string{"ref-capture-failed", "ref-credit-failed", "ref-no-workflow", "ref-status-failed"}
+	if !reflect.DeepEqual(result.Review, wantReview) {
+		t.Errorf("review = %v, want %v", result.Review, wantReview)
+	}
+
+	swept := map[string][]string{}
+	for _, call := range trxService.Calls() {
+		swept[call.Reference] = append(swept[call.Reference], call.Method)
+	}
+	wantSwept := map[string][]string{
+		"ref-create-failed": {"VoidHold", "UpdateStatus"},
+	}
+	if !reflect.DeepEqual(swept, wantSwept) {
+		t.Errorf("calls = %v, want %v", swept, wantSwept)
+	}
+}
+
+func TestSweepStuckLeavesMissingWorkflowsForReview(t *testing.T) {
+	ctx := context.Background()
+	repo := fakes.NewTransactionRepo()
+	// far past any namespace retention, the workflow may have charged the wallet before it was deleted
+	repo.Now = func() time.Time { return time.Now().Add(-90 * 24 * time.Hour) }
+	trxService := fakes.NewTransactionService()
+	if _, err := repo.CreateIfNotExists(ctx, &models.Transaction{Reference: "ref-old", Status: models.TransactionStatusPending, Type: models.TransactionTypePurchase}); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &services.MaintenanceService{
+		TransactionRepo:    repo,
+		TransactionService: trxService,
+		Temporal:           fakes.NewTemporal(),
+		Logger:             slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	for _, dryRun := range []bool{true, false} {
+		result, err := svc.SweepStuck(ctx, time.Hour, 100, dryRun)
+		if err != nil {
+			t.Fatalf("SweepStuck: %v", err)
+		}
+		if result.Swept != 0 || !reflect.DeepEqual(result.Review, []string{"ref-old"}) {
+			t.Errorf("dry run %v: swept %d review %v, want ref-old in review only", dryRun, result.Swept, result.Review)
+		}
+	}
+	if calls := trxService.Calls(); len(calls) != 0 {
+		t.Errorf("calls = %v, want the hold and the row left alone", calls)
+	}
+	trx, err := repo.FindByReference(ctx, "ref-old")
+	if err != nil || trx.Status != models.TransactionStatusPending {
+		t.Errorf("ref-old = %+v %v, want still pending", trx, err)
+	}
+}
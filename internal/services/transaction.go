@@ -6,80 +6,223 @@ import (
 	"ewallet-topup/internal/interfaces"
 	"ewallet-topup/internal/models"
 	"fmt"
+	"log/slog"
+
+	"github.com/pkg/errors"
 )
 
 type TransactionService struct {
 	TransactionRepo interfaces.ITransactionRepo
 	External        interfaces.IExternal
+	Logger          *slog.Logger
 }
 
-func NewTransactionService(Repo interfaces.ITransactionRepo, Ext interfaces.IExternal) *TransactionService {
+func NewTransactionService(Repo interfaces.ITransactionRepo, Ext interfaces.IExternal, Logger *slog.Logger) *TransactionService {
 	return &TransactionService{
 		TransactionRepo: Repo,
 		External:        Ext,
+		Logger:          Logger,
 	}
 }
 
 func (s *TransactionService) CreatePending(ctx context.Context, req models.CreateTransactionRequest) (*models.Transaction, error) {
 
-	trx := &models.Transaction{
+	// activity retries must not create the row or the hold twice
+	_, err := s.TransactionRepo.CreateIfNotExists(ctx, &models.Transaction{
 		UserID:      req.UserID,
 		Amount:      float64(req.Amount),
 		Type:        models.TransactionType(req.Type),
 		Status:      models.TransactionStatusPending,
 		Reference:   req.Referance,
 		Description: req.Description,
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	err := s.TransactionRepo.Create(ctx, trx)
+	trx, err := s.TransactionRepo.FindByReference(ctx, req.Referance)
 	if err != nil {
 		return nil, err
 	}
 
+	if trx.Type == models.TransactionTypePurchase && trx.HoldID == nil {
+		err = s.placeHold(ctx, trx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return trx, nil
 }
 
+func (s *TransactionService) placeHold(ctx context.Context, trx *models.Transaction) error {
+	resp, err := s.External.HoldBalance(ctx, external.HoldRequest{
+		Reference: trx.Reference,
+		Amount:    trx.Amount,
+		UserID:    trx.UserID,
+	})
+	if errors.Is(err, external.ErrDuplicateReference) {
+		// an earlier attempt placed the hold but did not get to save its id
+		resp, err = s.existingHold(ctx, trx)
+	}
+	if errors.Is(err, external.ErrInsufficientBalance) || errors.Is(err, external.ErrUnauthorized) || errors.Is(err, external.ErrHoldReleased) {
+		reason := err.Error()
+		if errUpdate := s.TransactionRepo.UpdateStatus(ctx, trx.Reference, models.TransactionStatusFailed, &reason); errUpdate != nil {
+			s.Logger.WarnContext(ctx, "failed to mark transaction failed", "reference", trx.Reference, "error", errUpdate)
+		}
+		return err
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to place wallet hold")
+	}
+
+	holdID := resp.Data.HoldID
+	err = s.TransactionRepo.UpdateHoldID(ctx, trx.Reference, holdID)
+	if err != nil {
+		// nothing else knows about the hold, release the funds instead of leaving them held.
+		// A retry then finds the hold voided and fails the transaction
+		if _, errVoid := s.External.VoidHold(ctx, holdID, external.HoldActionRequest{
+			Reference: trx.Reference,
+			UserID:    trx.UserID,
+		}); errVoid != nil {
+			s.Logger.ErrorContext(ctx, "failed to void unsaved wallet hold", "reference", trx.Reference, "hold_id", holdID, "error", errVoid)
+		}
+		return errors.Wrap(err, "failed to save hold id")
+	}
+	trx.HoldID = &holdID
+	return nil
+}
+
+// existingHold returns the hold already placed for the transaction, as long as it still holds the funds.
+func (s *TransactionService) existingHold(ctx context.Context, trx *models.Transaction) (*external.HoldResponse, error) {
+	resp, err := s.External.FindHold(ctx, external.HoldActionRequest{
+		Reference: trx.Reference,
+		UserID:    trx.UserID,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find existing wallet hold")
+	}
+	if resp.Data.Status != external.HoldStatusHeld {
+		return nil, errors.Wrapf(external.ErrHoldReleased, "hold %s is %s", resp.Data.HoldID, resp.Data.Status)
+	}
+	return resp, nil
+}
+
 func (s *TransactionService) UpdateStatus(ctx context.Context, ref string, status models.TransactionStatus, reason *string) error {
-	trx, err := s.TransactionRepo.FindByReferenceForUpdate(ctx, ref)
+	// lock the row so a concurrent update cannot slip in between the check and the write
+	return s.TransactionRepo.InTx(ctx, func(repo interfaces.ITransactionRepo) error {
+		trx, err := repo.FindByReferenceForUpdate(ctx, ref)
+		if err != nil {
+			return err
+		}
+		if !models.IsValidTransition(trx.Status, status) {
+			return fmt.Errorf("invalid status transition %s -> %s", trx.Status, status)
+		}
+		return repo.UpdateStatus(ctx, ref, status, reason)
+	})
+}
+
+func (s *TransactionService) CheckSufficientBalance(ctx context.Context, token string, amount float64) error {
+	resp, err := s.External.GetBalance(ctx, token)
 	if err != nil {
 		return err
 	}
-	if !models.IsValidTransition(trx.Status, status) {
-		return fmt.Errorf("invalid status transition %s -> %s", trx.Status, status)
+	if resp.Data.Balance < amount {
+		return errors.Wrapf(external.ErrInsufficientBalance, "balance %.2f is less than amount %.2f", resp.Data.Balance, amount)
 	}
-	return s.TransactionRepo.UpdateStatus(ctx, ref, status, reason)
+	return nil
 }
 
-func (s *TransactionService) DebitWallet(ctx context.Context, trx *models.Transaction, token string) error {
+func (s *TransactionService) DebitWallet(ctx context.Context, trx *models.Transaction) error {
 	req := external.UpdateBalance{
-		Amount:    float64(trx.Amount),
+		Amount:    trx.Amount,
 		Reference: trx.Reference,
+		UserID:    trx.UserID,
 	}
 
-	_, err := s.External.DebitBalance(ctx, token, req)
-	return err
+	resp, err := s.External.DebitBalance(ctx, req)
+	if err != nil {
+		return err
+	}
+	s.saveBalanceAfter(ctx, trx, resp.Data.Balance)
+	return nil
 }
 
-func (s *TransactionService) CreditWallet(ctx context.Context, trx *models.Transaction, token string) error {
+func (s *TransactionService) CreditWallet(ctx context.Context, trx *models.Transaction) error {
 	req := external.UpdateBalance{
-		Amount:    float64(trx.Amount),
+		Amount:    trx.Amount,
 		Reference: trx.Reference,
+		UserID:    trx.UserID,
+	}
+	resp, err := s.External.CreditBalance(ctx, req)
+	if err != nil {
+		return err
+	}
+	s.saveBalanceAfter(ctx, trx, resp.Data.Balance)
+	return nil
+}
+
+func (s *TransactionService) CaptureHold(ctx context.Context, trx *models.Transaction) error {
+	if trx.HoldID == nil {
+		return fmt.Errorf("transaction %s has no wallet hold", trx.Reference)
+	}
+	resp, err := s.External.CaptureHold(ctx, *trx.HoldID, external.HoldActionRequest{
+		Reference: trx.Reference,
+		UserID:    trx.UserID,
+	})
+	if err != nil {
+		return err
 	}
-	_, err := s.External.CreditBalance(ctx, token, req)
+	s.saveBalanceAfter(ctx, trx, resp.Data.Balance)
+	return nil
+}
+
+func (s *TransactionService) VoidHold(ctx context.Context, trx *models.Transaction) error {
+	if trx.HoldID == nil {
+		// nothing was reserved, e.g. a topup
+		return nil
+	}
+	_, err := s.External.VoidHold(ctx, *trx.HoldID, external.HoldActionRequest{
+		Reference: trx.Reference,
+		UserID:    trx.UserID,
+	})
 	return err
 }
 
+// saveBalanceAfter is best effort, the wallet has already moved the money at this point.
+func (s *TransactionService) saveBalanceAfter(ctx context.Context, trx *models.Transaction, balance float64) {
+	trx.BalanceAfter = &balance
+	err := s.TransactionRepo.UpdateBalanceAfter(ctx, trx.Reference, balance)
+	if err != nil {
+		s.Logger.WarnContext(ctx, "failed to save balance after", "reference", trx.Reference, "error", err)
+	}
+}
+
 func (s *TransactionService) SendNotification(ctx context.Context, trx *models.Transaction, user models.TokenData) {
-	if trx.Type != models.TransactionTypePurchase || trx.Status != models.TransactionStatusSuccess {
+	// trx comes from the workflow input, reload it to get the final status and balance
+	latest, err := s.TransactionRepo.FindByReference(ctx, trx.Reference)
+	if err != nil {
+		s.Logger.WarnContext(ctx, "failed to load transaction for notification", "reference", trx.Reference, "error", err)
+		return
+	}
+	if latest.Type != models.TransactionTypePurchase || latest.Status != models.TransactionStatusSuccess {
 		return
 	}
 
-	err := s.External.NotifyUserRegistered(
-		user.UserID,
-		user.Email,
-		user.FullName,
-	)
+	if user.Email == "" {
+		s.Logger.WarnContext(ctx, "sending notification without email", "reference", latest.Reference)
+	}
+
+	err = s.External.NotifyTransaction(ctx, external.TransactionNotification{
+		UserID:       user.UserID,
+		Email:        user.Email,
+		FullName:     user.FullName,
+		Reference:    latest.Reference,
+		Type:         string(latest.Type),
+		Amount:       latest.Amount,
+		BalanceAfter: latest.BalanceAfter,
+	})
 	if err != nil {
+		s.Logger.WarnContext(ctx, "failed to send notification", "reference", latest.Reference, "error", err)
 		return
 	}
 }
@@ -0,0 +1,132 @@
+package services_test
+
+import (
+	"context"
+	"ewallet-topup/external"
+	"ewallet-topup/internal/fakes"
+	"ewallet-topup/internal/models"
+	"ewallet-topup/internal/services"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// failingHoldRepo fails saving the hold id while fail is set.
+type failingHoldRepo struct {
+	*fakes.TransactionRepo
+	fail bool
+}
+
+func (r *failingHoldRepo) UpdateHoldID(ctx context.Context, reference string, holdID string) error {
+	if r.fail {
+		return errors.New("database is down")
+	}
+	return r.TransactionRepo.UpdateHoldID(ctx, reference, holdID)
+}
+
+func newPurchaseTest(t *testing.T, balance float64) (*services.TransactionService, *failingHoldRepo, *fakes.External) {
+	t.Helper()
+	repo := &failingHoldRepo{TransactionRepo: fakes.NewTransactionRepo()}
+	ext := fakes.NewExternal()
+	ext.AddUser("token-7", models.TokenData{UserID: 7}, balance)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return services.NewTransactionService(repo, ext, logger), repo, ext
+}
+
+func purchase(reference string, amount int64) models.CreateTransactionRequest {
+	return models.CreateTransactionRequest{
+		UserID:    7,
+		Type:      string(models.TransactionTypePurchase),
+		Amount:    amount,
+		Referance: reference,
+	}
+}
+
+func TestCreatePendingPlacesHold(t *testing.T) {
+	svc, _, ext := newPurchaseTest(t, 1000)
+
+	trx, err := svc.CreatePending(context.Background(), purchase("ref-1", 300))
+	if err != nil {
+		t.Fatalf("CreatePending: %v", err)
+	}
+	if trx.HoldID == nil {
+		t.Fatal("hold id not saved")
+	}
+	if got := ext.Balance(7); got != 700 {
+		t.Errorf("balance = %v, want 700", got)
+	}
+}
+
+func TestCreatePendingReusesHoldOfEarlierAttempt(t *testing.T) {
+	svc, _, ext := newPurchaseTest(t, 1000)
+	ctx := context.Background()
+
+	// the first attempt reached the wallet but its response was lost
+	earlier, err := ext.HoldBalance(ctx, external.HoldRequest{Reference: "ref-1", Amount: 300, UserID: 7})
+	if err != nil {
+		t.Fatalf("HoldBalance: %v", err)
+	}
+
+	trx, err := svc.CreatePending(ctx, purchase("ref-1", 300))
+	if err != nil {
+		t.Fatalf("CreatePending: %v", err)
+	}
+	if trx.HoldID == nil || *trx.HoldID != earlier.Data.HoldID {
+		t.Errorf("hold id = %v, want %s", trx.HoldID, earlier.Data.HoldID)
+	}
+	if got := ext.Balance(7); got != 700 {
+		t.Errorf("balance = %v, want 700, the amount held once", got)
+	}
+}
+
+func TestCreatePendingVoidsHoldItCannotSave(t *testing.T) {
+	svc, repo, ext := newPurchaseTest(t, 1000)
+	ctx := context.Background()
+
+	repo.fail = true
+	if _, err := svc.CreatePending(ctx, purchase("ref-1", 300)); err == nil {
+		t.Fatal("CreatePending succeeded without saving the hold id")
+	}
+	if got := ext.HoldStatus("ref-1"); got != fakes.HoldStatusVoided {
+		t.Errorf("hold status = %q, want %s", got, fakes.HoldStatusVoided)
+	}
+	if got := ext.Balance(7); got != 1000 {
+		t.Errorf("balance = %v, want 1000", got)
+	}
+
+	// the activity retry finds the released hold and gives up
+	repo.fail = false
+	_, err := svc.CreatePending(ctx, purchase("ref-1", 300))
+	if !errors.Is(err, external.ErrHoldReleased) {
+		t.Fatalf("retry error = %v, want %v", err, external.ErrHoldReleased)
+	}
+	trx, err := repo.FindByReference(ctx, "ref-1")
+	if err != nil {
+		t.Fatalf("FindByReference: %v", err)
+	}
+	if trx.Status != models.TransactionStatusFailed {
+		t.Errorf("status = %s, want %s", trx.Status, models.TransactionStatusFailed)
+	}
+}
+
+func TestCreatePendingInsufficientBalanceFails(t *testing.T) {
+	svc, repo, ext := newPurchaseTest(t, 100)
+	ctx := context.Background()
+
+	_, err := svc.CreatePending(ctx, purchase("ref-1", 300))
+	if !errors.Is(err, external.ErrInsufficientBalance) {
+		t.Fatalf("error = %v, want %v", err, external.ErrInsufficientBalance)
+	}
+	trx, err := repo.FindByReference(ctx, "ref-1")
+	if err != nil {
+		t.Fatalf("FindByReference: %v", err)
+	}
+	if trx.Status != models.TransactionStatusFailed || trx.HoldID != nil {
+		t.Errorf("transaction = %+v, want failed without hold", trx)
+	}
+	if got := ext.HoldStatus("ref-1"); got != "" {
+		t.Errorf("hold status = %q, want no hold", got)
+	}
+}
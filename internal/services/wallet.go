@@ -0,0 +1,25 @@
+package services
+
+import (
+	"context"
+	"ewallet-topup/external"
+	"ewallet-topup/internal/interfaces"
+
+	"github.com/pkg/errors"
+)
+
+type WalletService struct {
+	External interfaces.IExternal
+}
+
+func (s *WalletService) GetBalance(ctx context.Context, token string) (float64, error) {
+	resp, err := s.External.GetBalance(ctx, token)
+	if errors.Is(err, external.ErrUnauthorized) {
+		// the wallet no longer accepts this token, stop trusting the cached validation
+		s.External.InvalidateToken(token)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return resp.Data.Balance, nil
+}
@@ -0,0 +1,27 @@
+package tracing
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+)
+
+const TraceIDHeader = "X-Trace-Id"
+
+// GinMiddleware starts a server span per request and returns its trace id in X-Trace-Id.
+// Probes and metric scrapes are not traced.
+func GinMiddleware(serviceName string) []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		otelgin.Middleware(serviceName, otelgin.WithFilter(func(r *http.Request) bool {
+			return !strings.HasPrefix(r.URL.Path, "/health") && r.URL.Path != "/metrics"
+		})),
+		func(c *gin.Context) {
+			if traceID := TraceID(c.Request.Context()); traceID != "" {
+				c.Header(TraceIDHeader, traceID)
+			}
+			c.Next()
+		},
+	}
+}
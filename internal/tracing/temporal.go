@@ -0,0 +1,126 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/log"
+	"go.temporal.io/sdk/temporal"
+)
+
+// temporalHeaderKey is the header the span context travels in between client, workflow and activities.
+const temporalHeaderKey = "_tracer-data"
+
+type spanContextKey struct{}
+
+// temporalTracer implements interceptor.Tracer on top of the global otel provider,
+// so the StartWorkflow span of an http request is the parent of the workflow and its activities.
+type temporalTracer struct {
+	interceptor.BaseTracer
+}
+
+type temporalSpanRef struct {
+	trace.SpanContext
+}
+
+type temporalSpan struct {
+	trace.Span
+}
+
+// NewTemporalInterceptor is set on the client options, workers created from that client inherit it.
+func NewTemporalInterceptor() interceptor.Interceptor {
+	return interceptor.NewTracingInterceptor(&temporalTracer{})
+}
+
+func (t *temporalTracer) Options() interceptor.TracerOptions {
+	return interceptor.TracerOptions{
+		SpanContextKey: spanContextKey{},
+		HeaderKey:      temporalHeaderKey,
+	}
+}
+
+func (t *temporalTracer) UnmarshalSpan(m map[string]string) (interceptor.TracerSpanRef, error) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(m))
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil, fmt.Errorf("invalid span context in temporal header")
+	}
+	return &temporalSpanRef{SpanContext: sc}, nil
+}
+
+func (t *temporalTracer) MarshalSpan(span interceptor.TracerSpan) (map[string]string, error) {
+	data := map[string]string{}
+	s, ok := span.(*temporalSpan)
+	if !ok {
+		return data, nil
+	}
+	otel.GetTextMapPropagator().Inject(trace.ContextWithSpan(context.Background(), s.Span), propagation.MapCarrier(data))
+	return data, nil
+}
+
+func (t *temporalTracer) SpanFromContext(ctx context.Context) interceptor.TracerSpan {
+	span := trace.SpanFromContext(ctx)
+	if !span.SpanContext().IsValid() {
+		return nil
+	}
+	return &temporalSpan{Span: span}
+}
+
+func (t *temporalTracer) ContextWithSpan(ctx context.Context, span interceptor.TracerSpan) context.Context {
+	s, ok := span.(*temporalSpan)
+	if !ok {
+		return ctx
+	}
+	return trace.ContextWithSpan(ctx, s.Span)
+}
+
+func (t *temporalTracer) StartSpan(opts *interceptor.TracerStartSpanOptions) (interceptor.TracerSpan, error) {
+	ctx := context.Background()
+	switch parent := opts.Parent.(type) {
+	case *temporalSpan:
+		ctx = trace.ContextWithSpan(ctx, parent.Span)
+	case *temporalSpanRef:
+		ctx = trace.ContextWithRemoteSpanContext(ctx, parent.SpanContext)
+	}
+
+	attrs := make([]attribute.KeyValue, 0, len(opts.Tags))
+	for k, v := range opts.Tags {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+
+	_, span := Tracer().Start(ctx, t.SpanName(opts),
+		trace.WithTimestamp(opts.Time),
+		trace.WithAttributes(attrs...),
+	)
+	return &temporalSpan{Span: span}, nil
+}
+
+// GetLogger adds the trace id to workflow and activity log lines.
+func (t *temporalTracer) GetLogger(logger log.Logger, ref interceptor.TracerSpanRef) log.Logger {
+	var sc trace.SpanContext
+	switch span := ref.(type) {
+	case *temporalSpan:
+		sc = span.SpanContext()
+	case *temporalSpanRef:
+		sc = span.SpanContext
+	}
+	if !sc.IsValid() {
+		return logger
+	}
+	return log.With(logger, "trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+}
+
+func (s *temporalSpan) Finish(opts *interceptor.TracerFinishSpanOptions) {
+	// cancellation is not a failure
+	if opts.Error != nil && !temporal.IsCanceledError(opts.Error) {
+		s.RecordError(opts.Error)
+		s.SetStatus(codes.Error, opts.Error.Error())
+	}
+	s.End()
+}
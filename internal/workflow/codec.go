@@ -0,0 +1,165 @@
+package workflow
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"ewallet-topup/helpers"
+	"fmt"
+	"strings"
+
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/converter"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	MetadataEncodingEncrypted = "binary/encrypted"
+	MetadataEncryptionKeyID   = "encryption-key-id"
+)
+
+// EncryptionCodec encrypts every payload with AES-GCM before it reaches temporal history.
+// Payloads are always encrypted with the active key, old keys are kept for decoding only.
+type EncryptionCodec struct {
+	ActiveKeyID string
+	Keys        map[string][]byte
+}
+
+func NewEncryptionCodec(activeKeyID string, keys map[string][]byte) (*EncryptionCodec, error) {
+	if _, ok := keys[activeKeyID]; !ok {
+		return nil, fmt.Errorf("active payload encryption key %q not found", activeKeyID)
+	}
+	for id, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("payload encryption key %q must be 32 bytes, got %d", id, len(key))
+		}
+	}
+	return &EncryptionCodec{ActiveKeyID: activeKeyID, Keys: keys}, nil
+}
+
+type EncryptionConfig struct {
+	// Keys is "id:base64key,id:base64key"
+	Keys        string
+	ActiveKeyID string
+}
+
+// LoadEncryptionConfig reads PAYLOAD_ENCRYPTION_KEYS and PAYLOAD_ENCRYPTION_KEY_ID.
+func LoadEncryptionConfig(env *helpers.Env) EncryptionConfig {
+	return EncryptionConfig{
+		Keys:        env.Get("PAYLOAD_ENCRYPTION_KEYS", ""),
+		ActiveKeyID: env.Get("PAYLOAD_ENCRYPTION_KEY_ID", ""),
+	}
+}
+
+func (c EncryptionConfig) Enabled() bool {
+	return c.Keys != ""
+}
+
+// NewEncryptionCodecFromConfig parses the configured keys. It returns nil when no key is configured.
+func NewEncryptionCodecFromConfig(cfg EncryptionConfig) (*EncryptionCodec, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+
+	keys := map[string][]byte{}
+	for _, pair := range strings.Split(cfg.Keys, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid payload encryption key entry %q", pair)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload encryption key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+
+	return NewEncryptionCodec(cfg.ActiveKeyID, keys)
+}
+
+// NewDataConverter wraps the default temporal data converter with the encryption codec.
+func NewDataConverter(codec *EncryptionCodec) converter.DataConverter {
+	if codec == nil {
+		return converter.GetDefaultDataConverter()
+	}
+	return converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), codec)
+}
+
+func (c *EncryptionCodec) Encode(payloads []*commonpb.Payload) ([]*commonpb.Payload, error) {
+	result := make([]*commonpb.Payload, len(payloads))
+	for i, p := range payloads {
+		plain, err := proto.Marshal(p)
+		if err != nil {
+			return payloads, err
+		}
+		sealed, err := c.seal(c.Keys[c.ActiveKeyID], plain)
+		if err != nil {
+			return payloads, err
+		}
+		result[i] = &commonpb.Payload{
+			Metadata: map[string][]byte{
+				converter.MetadataEncoding: []byte(MetadataEncodingEncrypted),
+				MetadataEncryptionKeyID:    []byte(c.ActiveKeyID),
+			},
+			Data: sealed,
+		}
+	}
+	return result, nil
+}
+
+func (c *EncryptionCodec) Decode(payloads []*commonpb.Payload) ([]*commonpb.Payload, error) {
+	result := make([]*commonpb.Payload, len(payloads))
+	for i, p := range payloads {
+		if string(p.Metadata[converter.MetadataEncoding]) != MetadataEncodingEncrypted {
+			result[i] = p
+			continue
+		}
+		keyID := string(p.Metadata[MetadataEncryptionKeyID])
+		key, ok := c.Keys[keyID]
+		if !ok {
+			return payloads, fmt.Errorf("unknown payload encryption key %q", keyID)
+		}
+		plain, err := c.open(key, p.Data)
+		if err != nil {
+			return payloads, err
+		}
+		result[i] = &commonpb.Payload{}
+		if err := proto.Unmarshal(plain, result[i]); err != nil {
+			return payloads, err
+		}
+	}
+	return result, nil
+}
+
+func (c *EncryptionCodec) seal(key, plain []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (c *EncryptionCodec) open(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted payload too short")
+	}
+	nonce, data := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, data, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -8,9 +8,19 @@ import (
 )
 
 const (
+	// TransactionTaskQueue is the default TEMPORAL_TASK_QUEUE, every workflow ran on it
+	// before the per type queues
 	TransactionTaskQueue = "TRANSACTION_QUEUE"
+
+	// PendingTransactionTimeout is how long a transaction waits for confirm/cancel before it expires
+	PendingTransactionTimeout = 30 * time.Minute
 )
 
+// TransactionWorkflowID is the workflow id of the transaction with the given reference.
+func TransactionWorkflowID(reference string) string {
+	return "trx_" + reference
+}
+
 func DefaultActivityOptions() workflow.ActivityOptions {
 	return workflow.ActivityOptions{
 		StartToCloseTimeout: time.Minute,
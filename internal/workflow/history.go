@@ -0,0 +1,92 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	commonpb "go.temporal.io/api/common/v1"
+	enumspb "go.temporal.io/api/enums/v1"
+	historypb "go.temporal.io/api/history/v1"
+	"go.temporal.io/api/proxy"
+	"go.temporal.io/api/temporalproto"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/log"
+	"go.temporal.io/sdk/worker"
+)
+
+// ReplayResult is the outcome of replaying one history file, Err is nil when the current
+// workflow code produced the same commands as the recorded run.
+type ReplayResult struct {
+	File string
+	Err  error
+}
+
+// ReplayHistories replays every *.json history in dir, in file name order. The histories are
+// in the format written by ExportHistory and temporal workflow show --output json. It only
+// fails itself when dir cannot be read, per file errors are in the results.
+func ReplayHistories(replayer worker.WorkflowReplayer, logger log.Logger, dir string) ([]ReplayResult, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ReplayResult, 0, len(files))
+	for _, file := range files {
+		results = append(results, ReplayResult{File: file, Err: replayFile(replayer, logger, file)})
+	}
+	return results, nil
+}
+
+func replayFile(replayer worker.WorkflowReplayer, logger log.Logger, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	history, err := client.HistoryFromJSON(f, client.HistoryJSONOptions{})
+	if err != nil {
+		return fmt.Errorf("read history: %w", err)
+	}
+	return replayer.ReplayWorkflowHistory(logger, history)
+}
+
+// ExportHistory writes the full history of a workflow run as json, latest run when runID is
+// empty. With a codec the payloads are decrypted first, so the file replays without the
+// payload encryption keys but holds the workflow inputs, e.g. the user profile, in clear text.
+func ExportHistory(ctx context.Context, c client.Client, workflowID, runID string, codec *EncryptionCodec, w io.Writer) error {
+	history := &historypb.History{}
+	iter := c.GetWorkflowHistory(ctx, workflowID, runID, false, enumspb.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT)
+	for iter.HasNext() {
+		event, err := iter.Next()
+		if err != nil {
+			return fmt.Errorf("fetch history of %s: %w", workflowID, err)
+		}
+		history.Events = append(history.Events, event)
+	}
+
+	if codec != nil {
+		err := proxy.VisitPayloads(ctx, history, proxy.VisitPayloadsOptions{
+			Visitor: func(_ *proxy.VisitPayloadsContext, payloads []*commonpb.Payload) ([]*commonpb.Payload, error) {
+				return codec.Decode(payloads)
+			},
+			SkipSearchAttributes: true,
+		})
+		if err != nil {
+			return fmt.Errorf("decode history of %s: %w", workflowID, err)
+		}
+	}
+
+	data, err := temporalproto.CustomJSONMarshalOptions{Indent: "  "}.Marshal(history)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,40 @@
+package workflow
+
+import (
+	"context"
+	"ewallet-topup/helpers"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+)
+
+// LoggingInterceptor puts workflow_id, run_id and activity_type on every log line
+// written with the activity context, e.g. from the services the activities call.
+type LoggingInterceptor struct {
+	interceptor.WorkerInterceptorBase
+}
+
+func NewLoggingInterceptor() interceptor.WorkerInterceptor {
+	return &LoggingInterceptor{}
+}
+
+func (l *LoggingInterceptor) InterceptActivity(ctx context.Context, next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	i := &loggingActivityInbound{}
+	i.Next = next
+	return i
+}
+
+type loggingActivityInbound struct {
+	interceptor.ActivityInboundInterceptorBase
+}
+
+func (a *loggingActivityInbound) ExecuteActivity(ctx context.Context, in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	info := activity.GetInfo(ctx)
+	ctx = helpers.WithLogFields(ctx,
+		"workflow_id", info.WorkflowExecution.ID,
+		"run_id", info.WorkflowExecution.RunID,
+		"activity_type", info.ActivityType.Name,
+		"attempt", info.Attempt,
+	)
+	return a.Next.ExecuteActivity(ctx, in)
+}
@@ -0,0 +1,97 @@
+package workflow
+
+import (
+	"ewallet-topup/helpers"
+	"ewallet-topup/internal/models"
+	"strings"
+)
+
+// TransactionTypes have their own task queue and worker pool, so a burst of one type cannot
+// take the worker slots of another.
+var TransactionTypes = []models.TransactionType{
+	models.TransactionTypeTopup,
+	models.TransactionTypePurchase,
+	models.TransactionTypeRefund,
+}
+
+// WorkerConfig tunes the worker pool polling one task queue.
+type WorkerConfig struct {
+	MaxConcurrentActivities    int
+	MaxConcurrentWorkflowTasks int
+	ActivityPollers            int
+	WorkflowTaskPollers        int
+	// ActivitiesPerSecond is enforced by the server across every worker of the queue, 0 is unlimited
+	ActivitiesPerSecond float64
+}
+
+type TaskQueue struct {
+	Name   string
+	Worker WorkerConfig
+}
+
+// TaskQueues routes transaction workflows to the queue of their type.
+type TaskQueues struct {
+	// Default serves the types without a queue of their own and the workflows started
+	// before the per type queues, keep a worker on it until those are done
+	Default TaskQueue
+	ByType  map[models.TransactionType]TaskQueue
+}
+
+// LoadTaskQueues reads TEMPORAL_TASK_QUEUE and TEMPORAL_TASK_QUEUE_<TYPE>, e.g.
+// TEMPORAL_TASK_QUEUE_PURCHASE, the latter defaulting to the default queue name suffixed
+// with the type. TEMPORAL_WORKER_* tunes every pool and TEMPORAL_WORKER_<TYPE>_* overrides
+// it for one type, e.g. TEMPORAL_WORKER_PURCHASE_ACTIVITIES_PER_SECOND.
+func LoadTaskQueues(env *helpers.Env) TaskQueues {
+	base := loadWorkerConfig(env, "TEMPORAL_WORKER", WorkerConfig{
+		MaxConcurrentActivities:    50,
+		MaxConcurrentWorkflowTasks: 20,
+		ActivityPollers:            2,
+		WorkflowTaskPollers:        2,
+	})
+	queues := TaskQueues{
+		Default: TaskQueue{Name: env.Get("TEMPORAL_TASK_QUEUE", TransactionTaskQueue), Worker: base},
+		ByType:  map[models.TransactionType]TaskQueue{},
+	}
+	for _, t := range TransactionTypes {
+		suffix := strings.ToUpper(string(t))
+		queues.ByType[t] = TaskQueue{
+			Name:   env.Get("TEMPORAL_TASK_QUEUE_"+suffix, queues.Default.Name+"-"+strings.ToLower(suffix)),
+			Worker: loadWorkerConfig(env, "TEMPORAL_WORKER_"+suffix, base),
+		}
+	}
+	return queues
+}
+
+func loadWorkerConfig(env *helpers.Env, prefix string, def WorkerConfig) WorkerConfig {
+	return WorkerConfig{
+		MaxConcurrentActivities:    env.GetInt(prefix+"_MAX_CONCURRENT_ACTIVITIES", def.MaxConcurrentActivities),
+		MaxConcurrentWorkflowTasks: env.GetInt(prefix+"_MAX_CONCURRENT_WORKFLOW_TASKS", def.MaxConcurrentWorkflowTasks),
+		ActivityPollers:            env.GetInt(prefix+"_ACTIVITY_POLLERS", def.ActivityPollers),
+		WorkflowTaskPollers:        env.GetInt(prefix+"_WORKFLOW_TASK_POLLERS", def.WorkflowTaskPollers),
+		ActivitiesPerSecond:        env.GetFloat(prefix+"_ACTIVITIES_PER_SECOND", def.ActivitiesPerSecond),
+	}
+}
+
+// For returns the queue a transaction of type t is started on.
+func (q TaskQueues) For(t models.TransactionType) string {
+	if queue, ok := q.ByType[t]; ok {
+		return queue.Name
+	}
+	return q.Default.Name
+}
+
+// All returns every queue that needs a worker, the default queue first. A type configured
+// onto another queue's name shares that queue's worker.
+func (q TaskQueues) All() []TaskQueue {
+	queues := []TaskQueue{q.Default}
+	seen := map[string]bool{q.Default.Name: true}
+	for _, t := range TransactionTypes {
+		queue, ok := q.ByType[t]
+		if !ok || seen[queue.Name] {
+			continue
+		}
+		seen[queue.Name] = true
+		queues = append(queues, queue)
+	}
+	return queues
+}
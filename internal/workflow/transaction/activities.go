@@ -1,19 +1,22 @@
 package transaction
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"ewallet-topup/helpers"
+	"ewallet-topup/external"
 	"ewallet-topup/internal/interfaces"
+	"ewallet-topup/internal/metrics"
 	"ewallet-topup/internal/models"
-	"fmt"
-	"net/http"
-	"os"
-	"strings"
 
-	"github.com/rs/zerolog/log"
+	"github.com/pkg/errors"
 	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+)
+
+const (
+	ErrTypeInsufficientBalance = "InsufficientBalance"
+	ErrTypeWalletUnauthorized  = "WalletUnauthorized"
+	ErrTypeCircuitOpen         = "CircuitOpen"
+	ErrTypeHoldReleased        = "HoldReleased"
 )
 
 type TransactionActivities struct {
@@ -21,12 +24,6 @@ type TransactionActivities struct {
 	External interfaces.IExternal
 }
 
-type WalletRequest struct {
-	Reference string  `json:"reference"`
-	Amount    float64 `json:"amount"`
-	UserID    int64   `json:"user_id,omitempty"`
-}
-
 func (a *TransactionActivities) CreatePendingTransaction(ctx context.Context, req models.CreateTransactionRequest) (*models.Transaction, error) {
 
 	logger := activity.GetLogger(ctx)
@@ -34,8 +31,9 @@ func (a *TransactionActivities) CreatePendingTransaction(ctx context.Context, re
 
 	trx, err := a.Service.CreatePending(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, walletActivityError(err)
 	}
+	metrics.TransactionAmount.WithLabelValues(string(trx.Type)).Observe(trx.Amount)
 
 	return trx, nil
 }
@@ -51,138 +49,80 @@ func (a *TransactionActivities) UpdateTransactionStatus(ctx context.Context, ref
 func (a *TransactionActivities) DebitWallet(ctx context.Context, trx models.Transaction) error {
 
 	logger := activity.GetLogger(ctx)
-	logger.Info("credit wallet started", "reference", trx.Reference)
+	logger.Info("debit wallet started", "reference", trx.Reference)
 
-	walletHost := os.Getenv("WALLET_HOST")
-	endpoint := os.Getenv("WALLET_ENDPOINT_DEBIT")
-	url := strings.TrimRight(walletHost, "/") + "/" + strings.TrimLeft(endpoint, "/")
-
-	reqBody := WalletRequest{
-		Reference: trx.Reference,
-		Amount:    trx.Amount,
-		UserID:    trx.UserID,
+	err := a.Service.DebitWallet(ctx, &trx)
+	if errors.Is(err, external.ErrDuplicateReference) {
+		// a previous attempt already reached the wallet
+		logger.Warn("debit wallet already applied", "reference", trx.Reference)
+		return nil
 	}
-
-	data, err := json.Marshal(reqBody)
-	if err != nil {
-		log.Error().Err(err).Msg("failed to marshal wallet request")
-		return err
-	}
-
-	log.Debug().
-		Str("url", url).
-		Str("reference", trx.Reference).
-		Str("token", maskToken(trx.Token)).
-		Msg("sending request to wallet service")
-
-	req, err := http.NewRequest("PUT", url, bytes.NewReader(data))
 	if err != nil {
-		log.Error().Err(err).Msg("failed to create http request")
-		return err
+		logger.Error("debit wallet failed", "reference", trx.Reference, "error", err)
+		return walletActivityError(err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+trx.Token)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Error().Err(err).Msg("wallet service request failed")
-		return fmt.Errorf("wallet service request failed: %w", err)
-	}
-	defer resp.Body.Close()
+	logger.Info("debit wallet success", "reference", trx.Reference)
+	return nil
+}
 
-	var respBody map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil {
-		log.Error().Err(err).Msg("failed to decode wallet service response")
-		return fmt.Errorf("failed to decode wallet service response: %w", err)
-	}
+func (a *TransactionActivities) CreditWallet(ctx context.Context, trx models.Transaction) error {
 
-	log.Debug().
-		Int("status", resp.StatusCode).
-		Interface("body", respBody).
-		Msg("wallet service response")
+	logger := activity.GetLogger(ctx)
+	logger.Info("credit wallet started", "reference", trx.Reference)
 
-	if resp.StatusCode == 401 {
-		return fmt.Errorf("wallet service unauthorized: %d", resp.StatusCode)
+	err := a.Service.CreditWallet(ctx, &trx)
+	if errors.Is(err, external.ErrDuplicateReference) {
+		// a previous attempt already reached the wallet
+		logger.Warn("credit wallet already applied", "reference", trx.Reference)
+		return nil
 	}
-
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("wallet service error: %d - %v", resp.StatusCode, respBody)
+	if err != nil {
+		logger.Error("credit wallet failed", "reference", trx.Reference, "error", err)
+		return walletActivityError(err)
 	}
 
-	log.Info().Str("reference", trx.Reference).Msg("DebitWallet activity success")
+	logger.Info("credit wallet success", "reference", trx.Reference)
 	return nil
 }
 
-func (a *TransactionActivities) CreditWallet(ctx context.Context, trx models.Transaction, token string) error {
-	logger := activity.GetLogger(ctx)
-	logger.Info("credit wallet started", "reference", trx.Reference)
+func (a *TransactionActivities) CaptureWalletHold(ctx context.Context, trx models.Transaction) error {
 
-	// Ambil host & endpoint dari env
-	walletHost := helpers.GetEnv("WALLET_HOST", "")
-	if walletHost == "" {
-		walletHost = "http://localhost:8085" // fallback default
-	}
-	endpoint := helpers.GetEnv("WALLET_ENDPOINT_CREDIT", "")
-	if endpoint == "" {
-		endpoint = "/wallet/v1/balance/credit"
-	}
-	url := strings.TrimRight(walletHost, "/") + "/" + strings.TrimLeft(endpoint, "/")
+	logger := activity.GetLogger(ctx)
+	logger.Info("capture wallet hold started", "reference", trx.Reference)
 
-	// request body
-	body := WalletRequest{
-		Reference: trx.Reference,
-		Amount:    trx.Amount,
-		UserID:    trx.UserID,
+	err := a.Service.CaptureHold(ctx, &trx)
+	if errors.Is(err, external.ErrDuplicateReference) {
+		// a previous attempt already captured the hold
+		logger.Warn("wallet hold already captured", "reference", trx.Reference)
+		return nil
 	}
-	data, err := json.Marshal(body)
-	if err != nil {
-		log.Error().Err(err).Msg("failed to marshal wallet request")
-		return err
-	}
-	log.Debug().
-		Str("url", url).
-		Str("reference", trx.Reference).
-		Str("token", maskToken(token)).
-		Msg("sending request to wallet service")
-
-	req, err := http.NewRequest("PUT", url, bytes.NewReader(data))
 	if err != nil {
-		log.Error().Err(err).Msg("failed to create http request")
-		return err
+		logger.Error("capture wallet hold failed", "reference", trx.Reference, "error", err)
+		return walletActivityError(err)
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		logger.Error("wallet service request failed", "error", err)
-		return err
-	}
-	defer resp.Body.Close()
+	logger.Info("capture wallet hold success", "reference", trx.Reference)
+	return nil
+}
 
-	var respBody map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil {
-		log.Error().Err(err).Msg("failed to decode wallet service response")
-		return fmt.Errorf("failed to decode wallet service response: %w", err)
-	}
+func (a *TransactionActivities) VoidWalletHold(ctx context.Context, trx models.Transaction) error {
 
-	log.Debug().
-		Int("status", resp.StatusCode).
-		Interface("body", respBody).
-		Msg("wallet service response")
+	logger := activity.GetLogger(ctx)
+	logger.Info("void wallet hold started", "reference", trx.Reference)
 
-	if resp.StatusCode == 401 {
-		logger.Error("unauthorized: token invalid or expired")
-		return fmt.Errorf("wallet service unauthorized: 401")
+	err := a.Service.VoidHold(ctx, &trx)
+	if errors.Is(err, external.ErrDuplicateReference) {
+		// a previous attempt already voided the hold
+		logger.Warn("wallet hold already voided", "reference", trx.Reference)
+		return nil
 	}
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("wallet service error: %d - %s", resp.StatusCode, respBody)
+	if err != nil {
+		logger.Error("void wallet hold failed", "reference", trx.Reference, "error", err)
+		return walletActivityError(err)
 	}
 
-	logger.Info("credit wallet success", "reference", trx.Reference)
+	logger.Info("void wallet hold success", "reference", trx.Reference)
 	return nil
 }
 
@@ -196,3 +136,23 @@ func (a *TransactionActivities) SendNotification(ctx context.Context, trx *model
 
 	return nil
 }
+
+// walletActivityError stops temporal from retrying wallet rejections that will never succeed,
+// and delays the next attempt until an open circuit breaker allows calls again.
+func walletActivityError(err error) error {
+	var circuitErr *external.CircuitOpenError
+	switch {
+	case errors.As(err, &circuitErr):
+		return temporal.NewApplicationErrorWithOptions(err.Error(), ErrTypeCircuitOpen, temporal.ApplicationErrorOptions{
+			NextRetryDelay: circuitErr.RetryAfter,
+			Cause:          err,
+		})
+	case errors.Is(err, external.ErrInsufficientBalance):
+		return temporal.NewNonRetryableApplicationError(err.Error(), ErrTypeInsufficientBalance, err)
+	case errors.Is(err, external.ErrUnauthorized):
+		return temporal.NewNonRetryableApplicationError(err.Error(), ErrTypeWalletUnauthorized, err)
+	case errors.Is(err, external.ErrHoldReleased):
+		return temporal.NewNonRetryableApplicationError(err.Error(), ErrTypeHoldReleased, err)
+	}
+	return err
+}
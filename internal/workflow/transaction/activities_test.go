@@ -0,0 +1,374 @@
+//go:build cgo
+
+package transaction_test
+
+import (
+	"context"
+	"encoding/json"
+	"ewallet-topup/external"
+	notificationpb "ewallet-topup/external/proto/notification"
+	"ewallet-topup/external/proto/tokenvalidation"
+	"ewallet-topup/internal/fakes"
+	"ewallet-topup/internal/models"
+	"ewallet-topup/internal/repository"
+	"ewallet-topup/internal/services"
+	"ewallet-topup/internal/workflow/transaction"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testWallet is a wallet service over http that keeps one balance and its holds by reference.
+type testWallet struct {
+	mu      sync.Mutex
+	balance float64
+	holds   map[string]*external.WalletHold
+	held    map[string]float64
+	// calls lists "METHOD path" of every request
+	calls []string
+}
+
+func (w *testWallet) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.calls = append(w.calls, r.Method+" "+r.URL.Path)
+
+	var req external.HoldRequest
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&req)
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/wallet/v1/holds")
+	switch {
+	case r.Method == http.MethodPost && path == "":
+		if _, ok := w.holds[req.Reference]; ok {
+			writeWalletJSON(rw, http.StatusConflict, external.WalletErrorResponse{Message: "duplicate", Code: external.WalletCodeDuplicateReference})
+			return
+		}
+		if req.Amount > w.balance {
+			writeWalletJSON(rw, http.StatusUnprocessableEntity, external.WalletErrorResponse{Message: "saldo tidak cukup", Code: external.WalletCodeInsufficientBalance})
+			return
+		}
+		w.balance -= req.Amount
+		hold := &external.WalletHold{HoldID: "hold-" + req.Reference, Status: external.HoldStatusHeld, Balance: w.balance}
+		w.holds[req.Reference] = hold
+		w.held[req.Reference] = req.Amount
+		writeWalletJSON(rw, http.StatusCreated, external.HoldResponse{Message: "success", Data: *hold})
+	case r.Method == http.MethodPost && (strings.HasSuffix(path, "/capture") || strings.HasSuffix(path, "/void")):
+		hold, ok := w.holds[req.Reference]
+		if !ok || "/"+hold.HoldID+"/capture" != path && "/"+hold.HoldID+"/void" != path {
+			writeWalletJSON(rw, http.StatusNotFound, external.WalletErrorResponse{Message: "hold not found"})
+			return
+		}
+		if hold.Status != external.HoldStatusHeld {
+			writeWalletJSON(rw, http.StatusConflict, external.WalletErrorResponse{Message: "hold released", Code: external.WalletCodeDuplicateReference})
+			return
+		}
+		if strings.HasSuffix(path, "/void") {
+			hold.Status = external.HoldStatusVoided
+			w.balance += w.held[req.Reference]
+		} else {
+			hold.Status = external.HoldStatusCaptured
+		}
+		hold.Balance = w.balance
+		writeWalletJSON(rw, http.StatusOK, external.HoldResponse{Message: "success", Data: *hold})
+	default:
+		writeWalletJSON(rw, http.StatusNotFound, external.WalletErrorResponse{Message: "not found"})
+	}
+}
+
+// testAmounts is the purchase amount of each reference used below
+var testAmounts = map[string]float64{"ref-capture": 150, "ref-void": 300, "ref-poor": 5000}
+
+func (w *testWallet) requests() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.calls...)
+}
+
+func writeWalletJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// testUMS answers token-7 with user 7 and rejects any other token.
+type testUMS struct {
+	tokenvalidation.UnimplementedTokenValidationServer
+}
+
+func (testUMS) ValidateToken(_ context.Context, req *tokenvalidation.TokenRequest) (*tokenvalidation.TokenResponse, error) {
+	if req.Token != "token-7" {
+		return &tokenvalidation.TokenResponse{Message: "token tidak valid"}, nil
+	}
+	return &tokenvalidation.TokenResponse{
+		Message: "success",
+		Data:    &tokenvalidation.UserData{UserId: testUserID, Username: "budi", FullName: "Budi", Email: "budi@example.com"},
+	}, nil
+}
+
+// testNotification records every notification it is sent.
+type testNotification struct {
+	notificationpb.UnimplementedNotificationServiceServer
+
+	mu   sync.Mutex
+	sent []*notificationpb.SendNotificationRequest
+}
+
+func (n *testNotification) SendNotification(_ context.Context, req *notificationpb.SendNotificationRequest) (*notificationpb.SendNotificationResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent = append(n.sent, req)
+	return &notificationpb.SendNotificationResponse{NotificationId: int64(len(n.sent)), Status: "SUCCESS"}, nil
+}
+
+func (n *testNotification) requests() []*notificationpb.SendNotificationRequest {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]*notificationpb.SendNotificationRequest(nil), n.sent...)
+}
+
+// dialBufconn serves register on an in-memory listener and returns a client connection to it.
+func dialBufconn(t *testing.T, register func(*grpc.Server)) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	register(srv)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+type activityTest struct {
+	env          *testsuite.TestActivityEnvironment
+	activities   *transaction.TransactionActivities
+	ext          *external.External
+	repo         *repository.TransactionRepo
+	wallet       *testWallet
+	notification *testNotification
+}
+
+// newActivityTest wires the activities to sqlite and to real wallet, ums and notification
+// clients talking to in-process servers.
+func newActivityTest(t *testing.T, balance float64) *activityTest {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	db, pools, err := fakes.OpenSQLite(logger)
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	t.Cleanup(func() { _ = pools.Close() })
+	repo := &repository.TransactionRepo{DB: db}
+
+	wallet := &testWallet{balance: balance, holds: map[string]*external.WalletHold{}, held: map[string]float64{}}
+	srv := httptest.NewServer(wallet)
+	t.Cleanup(srv.Close)
+	breaker := external.BreakerConfig{
+		FailureThreshold: 3,
+		OpenTimeout:      time.Minute,
+		HalfOpenMaxCalls: 1,
+		MaxConcurrent:    4,
+		BulkheadWait:     time.Second,
+	}
+	walletClient := external.NewWalletClient(external.WalletConfig{
+		Host:            srv.URL,
+		CreditEndpoint:  "/wallet/v1/balance/credit",
+		DebitEndpoint:   "/wallet/v1/balance/debit",
+		BalanceEndpoint: "/wallet/v1/balance",
+		HoldEndpoint:    "/wallet/v1/holds",
+		Timeout:         time.Second,
+		DialTimeout:     time.Second,
+		IdleConnTimeout: time.Minute,
+		MaxIdleConns:    4,
+		MaxConnsPerHost: 4,
+		Breaker:         breaker,
+	}, external.NewServiceTokenSigner(external.ServiceTokenConfig{
+		Issuer:   "ewallet-topup",
+		Audience: "ewallet-wallet",
+		Secret:   []byte("test-secret"),
+		TTL:      time.Minute,
+	}))
+
+	// the clients dial the bufconn servers instead of their configured hosts
+	ums, err := external.NewUMSClient(external.UMSConfig{Host: "passthrough:///ums", Timeout: time.Second, MaxAttempts: 2, CacheSize: 10, Breaker: breaker}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = ums.Conn.Close()
+	ums.Conn = dialBufconn(t, func(s *grpc.Server) { tokenvalidation.RegisterTokenValidationServer(s, testUMS{}) })
+	ums.Client = tokenvalidation.NewTokenValidationClient(ums.Conn)
+
+	notification := &testNotification{}
+	notificationClient, err := external.NewNotificationClient(external.NotificationConfig{Host: "passthrough:///notification", Breaker: breaker}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = notificationClient.Conn.Close()
+	notificationClient.Conn = dialBufconn(t, func(s *grpc.Server) { notificationpb.RegisterNotificationServiceServer(s, notification) })
+	notificationClient.Client = notificationpb.NewNotificationServiceClient(notificationClient.Conn)
+
+	ext := &external.External{NotificationClient: notificationClient, Wallet: walletClient, UMS: ums}
+	activities := &transaction.TransactionActivities{
+		Service:  services.NewTransactionService(repo, ext, logger),
+		External: ext,
+	}
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+	return &activityTest{env: env, activities: activities, ext: ext, repo: repo, wallet: wallet, notification: notification}
+}
+
+// pendingPurchase validates the token with ums like the api does and creates the pending purchase.
+func (a *activityTest) pendingPurchase(t *testing.T, ref string) (*models.Transaction, models.TokenData, error) {
+	t.Helper()
+	user, err := a.ext.ValidateToken(context.Background(), "token-7")
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	val, err := a.env.ExecuteActivity(a.activities.CreatePendingTransaction, models.CreateTransactionRequest{
+		Referance:   ref,
+		UserID:      user.UserID,
+		Amount:      int64(testAmounts[ref]),
+		Type:        string(models.TransactionTypePurchase),
+		Description: "pulsa",
+		User:        user,
+	})
+	if err != nil {
+		return nil, user, err
+	}
+	var trx models.Transaction
+	if err := val.Get(&trx); err != nil {
+		t.Fatal(err)
+	}
+	return &trx, user, nil
+}
+
+func (a *activityTest) status(t *testing.T, ref string) models.TransactionStatus {
+	t.Helper()
+	trx, err := a.repo.FindByReference(context.Background(), ref)
+	if err != nil {
+		t.Fatalf("FindByReference(%s): %v", ref, err)
+	}
+	return trx.Status
+}
+
+func TestActivitiesCapturePurchase(t *testing.T) {
+	a := newActivityTest(t, 1000)
+
+	trx, user, err := a.pendingPurchase(t, "ref-capture")
+	if err != nil {
+		t.Fatalf("CreatePendingTransaction: %v", err)
+	}
+	if trx.HoldID == nil || *trx.HoldID != "hold-ref-capture" {
+		t.Fatalf("hold id = %v, want hold-ref-capture", trx.HoldID)
+	}
+	// a retried create finds the row and its hold and places nothing new
+	if _, _, err := a.pendingPurchase(t, "ref-capture"); err != nil {
+		t.Fatalf("retried CreatePendingTransaction: %v", err)
+	}
+
+	if _, err := a.env.ExecuteActivity(a.activities.CaptureWalletHold, *trx); err != nil {
+		t.Fatalf("CaptureWalletHold: %v", err)
+	}
+	// the wallet answers a second capture as already done
+	if _, err := a.env.ExecuteActivity(a.activities.CaptureWalletHold, *trx); err != nil {
+		t.Fatalf("retried CaptureWalletHold: %v", err)
+	}
+	if _, err := a.env.ExecuteActivity(a.activities.UpdateTransactionStatus, trx.Reference, models.TransactionStatusSuccess, (*string)(nil)); err != nil {
+		t.Fatalf("UpdateTransactionStatus: %v", err)
+	}
+	if _, err := a.env.ExecuteActivity(a.activities.SendNotification, trx, user); err != nil {
+		t.Fatalf("SendNotification: %v", err)
+	}
+
+	saved, err := a.repo.FindByReference(context.Background(), "ref-capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != models.TransactionStatusSuccess || saved.BalanceAfter == nil || *saved.BalanceAfter != 850 {
+		t.Errorf("transaction = %s balance %v, want SUCCESS with 850", saved.Status, saved.BalanceAfter)
+	}
+	if got := strings.Join(a.wallet.requests(), ","); got != "POST /wallet/v1/holds,POST /wallet/v1/holds/hold-ref-capture/capture,POST /wallet/v1/holds/hold-ref-capture/capture" {
+		t.Errorf("wallet requests = %s", got)
+	}
+
+	sent := a.notification.requests()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d notifications, want 1", len(sent))
+	}
+	if n := sent[0]; n.Event != "transaction_success" || n.UserId != testUserID ||
+		n.Payload.GetEmail().GetTo() != "budi@example.com" || n.Payload.GetPush().GetData()["balance"] != "850.00" {
+		t.Errorf("notification = %v", n)
+	}
+}
+
+func TestActivitiesVoidPurchase(t *testing.T) {
+	a := newActivityTest(t, 1000)
+
+	trx, user, err := a.pendingPurchase(t, "ref-void")
+	if err != nil {
+		t.Fatalf("CreatePendingTransaction: %v", err)
+	}
+	if _, err := a.env.ExecuteActivity(a.activities.VoidWalletHold, *trx); err != nil {
+		t.Fatalf("VoidWalletHold: %v", err)
+	}
+	reason := "dibatalkan"
+	if _, err := a.env.ExecuteActivity(a.activities.UpdateTransactionStatus, trx.Reference, models.TransactionStatusFailed, &reason); err != nil {
+		t.Fatalf("UpdateTransactionStatus: %v", err)
+	}
+	if _, err := a.env.ExecuteActivity(a.activities.SendNotification, trx, user); err != nil {
+		t.Fatalf("SendNotification: %v", err)
+	}
+
+	if got := a.status(t, "ref-void"); got != models.TransactionStatusFailed {
+		t.Errorf("status = %s, want FAILED", got)
+	}
+	if a.wallet.balance != 1000 {
+		t.Errorf("wallet balance = %v after void, want 1000", a.wallet.balance)
+	}
+	if n := len(a.notification.requests()); n != 0 {
+		t.Errorf("sent %d notifications for a cancelled purchase", n)
+	}
+}
+
+func TestActivitiesInsufficientBalance(t *testing.T) {
+	a := newActivityTest(t, 1000)
+
+	_, _, err := a.pendingPurchase(t, "ref-poor")
+	var appErr *temporal.ApplicationError
+	if !errors.As(err, &appErr) || appErr.Type() != transaction.ErrTypeInsufficientBalance || !appErr.NonRetryable() {
+		t.Fatalf("error = %v, want non-retryable %s", err, transaction.ErrTypeInsufficientBalance)
+	}
+	if got := a.status(t, "ref-poor"); got != models.TransactionStatusFailed {
+		t.Errorf("status = %s, want FAILED", got)
+	}
+}
+
+func TestActivitiesUnknownToken(t *testing.T) {
+	a := newActivityTest(t, 1000)
+	if _, err := a.ext.ValidateToken(context.Background(), "token-8"); err == nil {
+		t.Fatal("ums accepted an unknown token")
+	}
+}
@@ -0,0 +1,34 @@
+package transaction_test
+
+import (
+	"ewallet-topup/internal/workflow"
+	"ewallet-topup/internal/workflow/transaction"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"go.temporal.io/sdk/log"
+	"go.temporal.io/sdk/worker"
+)
+
+// TestReplayHistories replays the recorded corpus against the current workflow code, the same
+// check as ewallet replay-workflow -dir but without config. A failure means a change to the
+// commands of TransactionWorkflow is missing its workflow.GetVersion gate.
+func TestReplayHistories(t *testing.T) {
+	replayer := worker.NewWorkflowReplayer()
+	replayer.RegisterWorkflow(transaction.TransactionWorkflow)
+
+	results, err := workflow.ReplayHistories(replayer, log.NewStructuredLogger(slog.New(slog.NewTextHandler(io.Discard, nil))), "testdata/histories")
+	if err != nil {
+		t.Fatalf("ReplayHistories: %v", err)
+	}
+	if len(results) == 0 {
+		t.Fatal("no histories in testdata/histories")
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: %v", filepath.Base(r.File), r.Err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package transaction
+
+import (
+	"ewallet-topup/internal/models"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	UpdateTransactionConfirm = "transaction.confirm"
+	UpdateTransactionCancel  = "transaction.cancel"
+
+	// rejections of the confirm and cancel updates, the api maps them to 404 and 409
+	ErrTypeTransactionNotFound   = "TransactionNotFound"
+	ErrTypeTransactionNotPending = "TransactionNotPending"
+)
+
+// UpdateTransaction is the argument of the confirm and cancel updates.
+type UpdateTransaction struct {
+	// UserID must own the transaction, other users are told it does not exist
+	UserID int64
+	Reason *string
+}
+
+type decision struct {
+	confirm bool
+	reason  *string
+}
+
+// decisions hands confirm and cancel from the update handlers to step 2 of the workflow.
+type decisions struct {
+	ch workflow.Channel
+	// decided is set once a confirm or cancel was accepted, by update or signal
+	decided bool
+	// settled is set once the decision has been applied, the update handlers return then
+	settled bool
+}
+
+func newDecisions(ctx workflow.Context) *decisions {
+	// the validator lets one decision through, so a single slot never blocks
+	return &decisions{ch: workflow.NewBufferedChannel(ctx, 1)}
+}
+
+// RegisterUpdates adds the confirm and cancel updates. Unlike the signals they are validated
+// before they reach the history and return the state once the decision has been applied.
+func RegisterUpdates(ctx workflow.Context, state *TransactionState, d *decisions) {
+	updates := []struct {
+		name    string
+		confirm bool
+	}{
+		{UpdateTransactionConfirm, true},
+		{UpdateTransactionCancel, false},
+	}
+	for _, u := range updates {
+		err := workflow.SetUpdateHandlerWithOptions(ctx, u.name,
+			func(ctx workflow.Context, req UpdateTransaction) (TransactionState, error) {
+				d.decided = true
+				d.ch.Send(ctx, decision{confirm: u.confirm, reason: req.Reason})
+				if err := workflow.Await(ctx, func() bool { return d.settled }); err != nil {
+					return TransactionState{}, err
+				}
+				return *state, nil
+			},
+			workflow.UpdateHandlerOptions{
+				Validator: func(ctx workflow.Context, req UpdateTransaction) error {
+					return validateDecision(state, d, req)
+				},
+			},
+		)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func validateDecision(state *TransactionState, d *decisions, req UpdateTransaction) error {
+	if req.UserID != state.UserID {
+		return temporal.NewNonRetryableApplicationError("transaction not found", ErrTypeTransactionNotFound, nil)
+	}
+	// confirm may arrive before the pending row exists, it is applied once step 2 starts
+	waiting := state.Step == "INIT" || state.Step == "PENDING_CREATE"
+	if d.decided || !waiting || state.Status != models.TransactionStatusPending {
+		return temporal.NewNonRetryableApplicationError("transaction is no longer pending, step "+state.Step, ErrTypeTransactionNotPending, nil)
+	}
+	return nil
+}
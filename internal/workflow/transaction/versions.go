@@ -0,0 +1,21 @@
+package transaction
+
+// Change ids for workflow.GetVersion. Transactions wait in step 2 for up to
+// PendingTransactionTimeout, so a deploy always meets open workflows that started on the
+// old code and are replayed by the new one. Any change to the commands TransactionWorkflow
+// issues (activities, timers, their order) needs a new change id here, gated with
+// workflow.GetVersion(ctx, id, workflow.DefaultVersion, n) so old histories keep taking
+// the old branch. Once no open workflow can reach the old branch, raise the min version
+// instead of removing the GetVersion call, and record a history for the corpus in
+// testdata/histories with: ewallet export-history -reference <ref>
+const (
+	// ChangeWalletHold captures the purchase hold on confirm and voids it on cancel or expiry.
+	// Version 1, read before CreatePendingTransaction.
+	ChangeWalletHold = "wallet-hold"
+	// ChangePendingTimeout expires a transaction left in step 2 for PendingTransactionTimeout
+	// with a timer. Version 1.
+	ChangePendingTimeout = "pending-timeout"
+	// ChangeVoidBeforeFail voids the purchase hold before the transaction is marked failed,
+	// so a failed row always means the funds are released. Version 1.
+	ChangeVoidBeforeFail = "void-before-fail"
+)
@@ -2,99 +2,229 @@
 package transaction
 
 import (
+	"ewallet-topup/internal/metrics"
 	"ewallet-topup/internal/models"
 	workflows "ewallet-topup/internal/workflow"
+	"time"
 
 	"go.temporal.io/sdk/workflow"
 )
 
 type TransactionState struct {
 	Reference string
+	UserID    int64
+	Type      models.TransactionType
 	Status    models.TransactionStatus
 	Step      string
 	Reason    *string
+	// Steps lists every step reached with its workflow time, oldest first
+	Steps []StepTransition
+	// LastError is the last activity failure, after its retries ran out. Attempts of a
+	// running activity are only known to temporal, see DescribeWorkflowExecution
+	LastError *string
+	// Deadline is when the transaction expires unless it is confirmed or cancelled first
+	Deadline *time.Time
 }
 
-func TransactionWorkflow(ctx workflow.Context, req models.CreateTransactionRequest) error {
-	logger := workflow.GetLogger(ctx)
-	logger.Info("transaction workflow started", "reference", req.Referance)
+type StepTransition struct {
+	Step string
+	At   time.Time
+}
 
+func (s *TransactionState) enter(ctx workflow.Context, step string) {
+	s.Step = step
+	s.Steps = append(s.Steps, StepTransition{Step: step, At: workflow.Now(ctx)})
+}
+
+func (s *TransactionState) fail(ctx workflow.Context, step string, err error) {
+	s.enter(ctx, step)
+	msg := err.Error()
+	s.LastError = &msg
+}
+
+func TransactionWorkflow(ctx workflow.Context, req models.CreateTransactionRequest) error {
 	state := TransactionState{
 		Reference: req.Referance,
+		UserID:    req.UserID,
+		Type:      models.TransactionType(req.Type),
 		Status:    models.TransactionStatusPending,
-		Step:      "INIT",
 	}
+	state.enter(ctx, "INIT")
+	d := newDecisions(ctx)
 
 	RegisterQueries(ctx, &state)
+	RegisterUpdates(ctx, &state, d)
+
+	err := runTransaction(ctx, req, &state, d)
+	// let running confirm and cancel updates return the final state before the workflow closes
+	d.settled = true
+	if errWait := workflow.Await(ctx, func() bool { return workflow.AllHandlersFinished(ctx) }); errWait != nil && err == nil {
+		err = errWait
+	}
+	return err
+}
+
+func runTransaction(ctx workflow.Context, req models.CreateTransactionRequest, state *TransactionState, d *decisions) error {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("transaction workflow started", "reference", req.Referance)
 	ctx = workflow.WithActivityOptions(ctx, workflows.DefaultActivityOptions())
 
+	// read at the start so a workflow that began before purchase holds replays as DefaultVersion
+	// and never voids or captures a hold its CreatePendingTransaction did not place
+	holds := workflow.GetVersion(ctx, ChangeWalletHold, workflow.DefaultVersion, 1) >= 1
+
 	var trx models.Transaction
 	// STEP 1: create pending transaction
 	logger.Debug("executing CreatePendingTransaction activity", "reference", req.Referance)
 	if err := workflow.ExecuteActivity(ctx, (*TransactionActivities).CreatePendingTransaction, req).Get(ctx, &trx); err != nil {
-		state.Step = "CREATE_PENDING_FAILED"
+		state.fail(ctx, "CREATE_PENDING_FAILED", err)
 		state.Status = models.TransactionStatusFailed
 		logger.Error("CreatePendingTransaction failed", "error", err)
 		return err
 	}
-	state.Step = "PENDING_CREATE"
+	state.enter(ctx, "PENDING_CREATE")
+	recordTransactionEvent(ctx, trx.Type, metrics.TransactionEventCreated)
 	logger.Info("pending transaction created", "reference", trx.Reference)
 
-	// STEP 2: wait for confirmation
-	logger.Debug("waiting for confirmation signal", "reference", trx.Reference)
+	// STEP 2: wait for confirmation, by update or signal
+	logger.Debug("waiting for confirmation", "reference", trx.Reference)
 	confirmed := false
 	selector := workflow.NewSelector(ctx)
 
+	selector.AddReceive(d.ch, func(c workflow.ReceiveChannel, more bool) {
+		var dec decision
+		c.Receive(ctx, &dec)
+		confirmed = dec.confirm
+		state.Reason = dec.reason
+		logger.Info("transaction decision update received", "reference", trx.Reference, "confirmed", confirmed)
+	})
+
 	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalTransactionConfirm), func(c workflow.ReceiveChannel, more bool) {
-		c.Receive(ctx, nil)
+		var sig SignalTransaction
+		c.Receive(ctx, &sig)
 		confirmed = true
+		d.decided = true
+		state.Reason = sig.Reason
 		logger.Info("transaction confirmed signal received", "reference", trx.Reference)
 	})
 
 	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalTransactionCancel), func(c workflow.ReceiveChannel, more bool) {
-		c.Receive(ctx, nil)
+		var sig SignalTransaction
+		c.Receive(ctx, &sig)
 		confirmed = false
+		d.decided = true
+		state.Reason = sig.Reason
 		logger.Info("transaction cancel signal received", "reference", trx.Reference)
 	})
 
+	expired := false
+	cancelTimer := func() {}
+	if workflow.GetVersion(ctx, ChangePendingTimeout, workflow.DefaultVersion, 1) >= 1 {
+		deadline := workflow.Now(ctx).Add(workflows.PendingTransactionTimeout)
+		state.Deadline = &deadline
+		var timerCtx workflow.Context
+		timerCtx, cancelTimer = workflow.WithCancel(ctx)
+		selector.AddFuture(workflow.NewTimer(timerCtx, workflows.PendingTransactionTimeout), func(f workflow.Future) {
+			if err := f.Get(timerCtx, nil); err != nil {
+				return
+			}
+			expired = true
+			d.decided = true
+			logger.Info("transaction expired waiting for confirmation", "reference", trx.Reference)
+		})
+	}
+
 	selector.Select(ctx)
+	cancelTimer()
 
 	if !confirmed {
-		state.Step = "CANCELLED"
+		step := "CANCELLED"
+		event := metrics.TransactionEventFailed
+		reason := state.Reason
+		if expired {
+			step = "EXPIRED"
+			event = metrics.TransactionEventExpired
+			expiredReason := "transaction expired"
+			reason = &expiredReason
+			state.Reason = reason
+		}
+		state.enter(ctx, step)
 		state.Status = models.TransactionStatusFailed
+		recordTransactionEvent(ctx, trx.Type, event)
+
+		if !holds {
+			_ = workflow.ExecuteActivity(ctx, (*TransactionActivities).UpdateTransactionStatus, trx.Reference, models.TransactionStatusFailed, reason).Get(ctx, nil)
+			return nil
+		}
+
+		voidFirst := workflow.GetVersion(ctx, ChangeVoidBeforeFail, workflow.DefaultVersion, 1) >= 1
+		if !voidFirst {
+			_ = workflow.ExecuteActivity(ctx, (*TransactionActivities).UpdateTransactionStatus, trx.Reference, models.TransactionStatusFailed, reason).Get(ctx, nil)
+		}
+
+		// release the purchase hold so the funds are available again
+		if err := workflow.ExecuteActivity(ctx, (*TransactionActivities).VoidWalletHold, trx).Get(ctx, nil); err != nil {
+			state.fail(ctx, "VOID_FAILED", err)
+			logger.Error("VoidWalletHold failed", "error", err)
+			return err
+		}
 
-		_ = workflow.ExecuteActivity(ctx, (*TransactionActivities).UpdateTransactionStatus, trx.Reference, models.TransactionStatusFailed, nil).Get(ctx, nil)
+		if voidFirst {
+			if err := workflow.ExecuteActivity(ctx, (*TransactionActivities).UpdateTransactionStatus, trx.Reference, models.TransactionStatusFailed, reason).Get(ctx, nil); err != nil {
+				state.fail(ctx, "UPDATE_STATUS_FAILED", err)
+				logger.Error("UpdateTransactionStatus failed", "error", err)
+				return err
+			}
+		}
 		return nil
 	}
 
-	state.Step = "CONFIRMED"
+	state.enter(ctx, "CONFIRMED")
 
 	// STEP 3: wallet operation
 	if trx.Type == models.TransactionTypeTopup {
-		logger.Debug("executing CreditWallet activity", "reference", trx.Reference, "token", maskToken(req.Token))
-		var walletResp interface{}
-		err := workflow.ExecuteActivity(ctx, (*TransactionActivities).CreditWallet, trx, req.Token).Get(ctx, &walletResp)
+		logger.Debug("executing CreditWallet activity", "reference", trx.Reference)
+		err := workflow.ExecuteActivity(ctx, (*TransactionActivities).CreditWallet, trx).Get(ctx, nil)
 		if err != nil {
-			state.Step = "CREDIT_FAILED"
+			state.fail(ctx, "CREDIT_FAILED", err)
 			state.Status = models.TransactionStatusFailed
+			recordTransactionEvent(ctx, trx.Type, metrics.TransactionEventFailed)
 			logger.Error("CreditWallet failed", "error", err)
 			return err
 		}
 		logger.Info("CreditWallet activity success", "reference", trx.Reference)
 	}
+	if trx.Type == models.TransactionTypePurchase && holds {
+		logger.Debug("executing CaptureWalletHold activity", "reference", trx.Reference)
+		err := workflow.ExecuteActivity(ctx, (*TransactionActivities).CaptureWalletHold, trx).Get(ctx, nil)
+		if err != nil {
+			state.fail(ctx, "CAPTURE_FAILED", err)
+			state.Status = models.TransactionStatusFailed
+			recordTransactionEvent(ctx, trx.Type, metrics.TransactionEventFailed)
+			logger.Error("CaptureWalletHold failed", "error", err)
+			return err
+		}
+		logger.Info("CaptureWalletHold activity success", "reference", trx.Reference)
+	}
 
 	// STEP 4: update status success
 	if err := workflow.ExecuteActivity(ctx, (*TransactionActivities).UpdateTransactionStatus, trx.Reference, models.TransactionStatusSuccess, nil).Get(ctx, nil); err != nil {
-		state.Step = "UPDATE_STATUS_FAILED"
+		state.fail(ctx, "UPDATE_STATUS_FAILED", err)
 		logger.Error("UpdateTransactionStatus failed", "error", err)
 		return err
 	}
-	state.Step = "SUCCESS"
+	state.enter(ctx, "SUCCESS")
 	state.Status = models.TransactionStatusSuccess
+	d.settled = true
+	recordTransactionEvent(ctx, trx.Type, metrics.TransactionEventSucceeded)
 
 	// STEP 5: send notification
-	if err := workflow.ExecuteActivity(ctx, (*TransactionActivities).SendNotification, trx, models.TokenData{UserID: req.UserID}).Get(ctx, nil); err != nil {
-		state.Step = "SEND_NOTIFICATION_FAILED"
+	user := req.User
+	if user.UserID == 0 {
+		user.UserID = req.UserID
+	}
+	if err := workflow.ExecuteActivity(ctx, (*TransactionActivities).SendNotification, trx, user).Get(ctx, nil); err != nil {
+		state.fail(ctx, "SEND_NOTIFICATION_FAILED", err)
 		logger.Error("SendNotification failed", "error", err)
 		return err
 	}
@@ -102,10 +232,10 @@ func TransactionWorkflow(ctx workflow.Context, req models.CreateTransactionReque
 	return nil
 }
 
-// mask token supaya aman di log
-func maskToken(token string) string {
-	if len(token) <= 8 {
-		return "****"
-	}
-	return token[:4] + "****" + token[len(token)-4:]
+// recordTransactionEvent goes through the workflow metrics handler, which skips emitting during replay.
+func recordTransactionEvent(ctx workflow.Context, trxType models.TransactionType, event string) {
+	workflow.GetMetricsHandler(ctx).WithTags(map[string]string{
+		"type":  string(trxType),
+		"event": event,
+	}).Counter(metrics.TransactionEvents).Inc(1)
 }
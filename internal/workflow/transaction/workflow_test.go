@@ -0,0 +1,354 @@
+package transaction_test
+
+import (
+	"context"
+	"ewallet-topup/external"
+	"ewallet-topup/internal/fakes"
+	"ewallet-topup/internal/models"
+	"ewallet-topup/internal/services"
+	"ewallet-topup/internal/workflow/transaction"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.temporal.io/sdk/log"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+)
+
+const testUserID = 7
+
+type workflowTest struct {
+	t    *testing.T
+	env  *testsuite.TestWorkflowEnvironment
+	repo *fakes.TransactionRepo
+	ext  *fakes.External
+	// updates numbers the update ids, the environment dedupes updates sent with the same id
+	updates int
+}
+
+// newWorkflowTest runs TransactionWorkflow with the real activities and service on top of the
+// in-memory repo and wallet. The user starts with balance.
+func newWorkflowTest(t *testing.T, balance float64) *workflowTest {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var suite testsuite.WorkflowTestSuite
+	suite.SetLogger(log.NewStructuredLogger(logger))
+	env := suite.NewTestWorkflowEnvironment()
+
+	repo := fakes.NewTransactionRepo()
+	ext := fakes.NewExternal()
+	ext.AddUser("token-7", models.TokenData{UserID: testUserID, Email: "budi@example.com", FullName: "Budi"}, balance)
+	env.RegisterWorkflow(transaction.TransactionWorkflow)
+	env.RegisterActivity(&transaction.TransactionActivities{
+		Service:  services.NewTransactionService(repo, ext, logger),
+		External: ext,
+	})
+	return &workflowTest{t: t, env: env, repo: repo, ext: ext}
+}
+
+func request(ref string, trxType models.TransactionType, amount int64) models.CreateTransactionRequest {
+	return models.CreateTransactionRequest{
+		Referance:   ref,
+		UserID:      testUserID,
+		Amount:      amount,
+		Type:        string(trxType),
+		Description: "test",
+		User:        models.TokenData{UserID: testUserID, Email: "budi@example.com", FullName: "Budi"},
+	}
+}
+
+// updateResult is what the environment reported back for one update.
+type updateResult struct {
+	accepted bool
+	rejected error
+	state    transaction.TransactionState
+	err      error
+}
+
+// update sends a confirm or cancel update after delay and records the outcome.
+func (w *workflowTest) update(delay time.Duration, name string, req transaction.UpdateTransaction) *updateResult {
+	res := &updateResult{}
+	w.updates++
+	id := fmt.Sprintf("%s-%d", name, w.updates)
+	w.env.RegisterDelayedCallback(func() {
+		w.env.UpdateWorkflow(name, id, &testsuite.TestUpdateCallback{
+			OnAccept: func() { res.accepted = true },
+			OnReject: func(err error) { res.rejected = err },
+			OnComplete: func(state interface{}, err error) {
+				res.err = err
+				if s, ok := state.(transaction.TransactionState); ok {
+					res.state = s
+				}
+			},
+		}, req)
+	}, delay)
+	return res
+}
+
+func (w *workflowTest) run(req models.CreateTransactionRequest) error {
+	w.env.ExecuteWorkflow(transaction.TransactionWorkflow, req)
+	if !w.env.IsWorkflowCompleted() {
+		w.t.Fatal("workflow did not complete")
+	}
+	return w.env.GetWorkflowError()
+}
+
+func (w *workflowTest) state() transaction.TransactionState {
+	w.t.Helper()
+	value, err := w.env.QueryWorkflow(transaction.QueryTransactionState)
+	if err != nil {
+		w.t.Fatalf("query state: %v", err)
+	}
+	var state transaction.TransactionState
+	if err := value.Get(&state); err != nil {
+		w.t.Fatal(err)
+	}
+	return state
+}
+
+func (w *workflowTest) row(ref string) *models.Transaction {
+	w.t.Helper()
+	trx, err := w.repo.FindByReference(context.Background(), ref)
+	if err != nil {
+		w.t.Fatalf("FindByReference(%s): %v", ref, err)
+	}
+	return trx
+}
+
+func steps(state transaction.TransactionState) []string {
+	var out []string
+	for _, s := range state.Steps {
+		out = append(out, s.Step)
+	}
+	return out
+}
+
+func assertSteps(t *testing.T, state transaction.TransactionState, want ...string) {
+	t.Helper()
+	got := steps(state)
+	if len(got) != len(want) {
+		t.Fatalf("steps = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("steps = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestTopupConfirmed(t *testing.T) {
+	w := newWorkflowTest(t, 1000)
+	confirm := w.update(time.Minute, transaction.UpdateTransactionConfirm, transaction.UpdateTransaction{UserID: testUserID})
+
+	if err := w.run(request("ref-1", models.TransactionTypeTopup, 500)); err != nil {
+		t.Fatalf("workflow error: %v", err)
+	}
+	if !confirm.accepted || confirm.err != nil || confirm.state.Status != models.TransactionStatusSuccess {
+		t.Errorf("confirm update = %+v, want accepted with the successful state", confirm)
+	}
+	assertSteps(t, w.state(), "INIT", "PENDING_CREATE", "CONFIRMED", "SUCCESS")
+	if got := w.ext.Balance(testUserID); got != 1500 {
+		t.Errorf("balance = %v, want 1500", got)
+	}
+	row := w.row("ref-1")
+	if row.Status != models.TransactionStatusSuccess || row.BalanceAfter == nil || *row.BalanceAfter != 1500 {
+		t.Errorf("row = %+v", row)
+	}
+	if n := len(w.ext.Notifications()); n != 0 {
+		t.Errorf("%d notifications sent for a topup, want none", n)
+	}
+}
+
+func TestPurchaseConfirmedBySignal(t *testing.T) {
+	w := newWorkflowTest(t, 1000)
+	w.env.RegisterDelayedCallback(func() {
+		w.env.SignalWorkflow(transaction.SignalTransactionConfirm, transaction.SignalTransaction{})
+	}, time.Minute)
+
+	if err := w.run(request("ref-1", models.TransactionTypePurchase, 300)); err != nil {
+		t.Fatalf("workflow error: %v", err)
+	}
+	assertSteps(t, w.state(), "INIT", "PENDING_CREATE", "CONFIRMED", "SUCCESS")
+	if got := w.ext.HoldStatus("ref-1"); got != fakes.HoldStatusCaptured {
+		t.Errorf("hold = %s, want captured", got)
+	}
+	if got := w.ext.Balance(testUserID); got != 700 {
+		t.Errorf("balance = %v, want 700", got)
+	}
+	notifications := w.ext.Notifications()
+	if len(notifications) != 1 || notifications[0].Email != "budi@example.com" || notifications[0].Reference != "ref-1" {
+		t.Errorf("notifications = %+v", notifications)
+	}
+}
+
+func TestPurchaseCancelled(t *testing.T) {
+	w := newWorkflowTest(t, 1000)
+	reason := "salah nominal"
+	cancel := w.update(time.Minute, transaction.UpdateTransactionCancel, transaction.UpdateTransaction{UserID: testUserID, Reason: &reason})
+
+	if err := w.run(request("ref-1", models.TransactionTypePurchase, 300)); err != nil {
+		t.Fatalf("workflow error: %v", err)
+	}
+	if !cancel.accepted || cancel.state.Status != models.TransactionStatusFailed {
+		t.Errorf("cancel update = %+v", cancel)
+	}
+	state := w.state()
+	assertSteps(t, state, "INIT", "PENDING_CREATE", "CANCELLED")
+	if state.Reason == nil || *state.Reason != reason {
+		t.Errorf("state reason = %v, want %q", state.Reason, reason)
+	}
+	if got := w.ext.HoldStatus("ref-1"); got != fakes.HoldStatusVoided {
+		t.Errorf("hold = %s, want voided", got)
+	}
+	if got := w.ext.Balance(testUserID); got != 1000 {
+		t.Errorf("balance = %v, want 1000", got)
+	}
+	row := w.row("ref-1")
+	if row.Status != models.TransactionStatusFailed || row.AdditionalInfo == nil || *row.AdditionalInfo != reason {
+		t.Errorf("row = %+v", row)
+	}
+}
+
+func TestPurchaseExpires(t *testing.T) {
+	w := newWorkflowTest(t, 1000)
+
+	if err := w.run(request("ref-1", models.TransactionTypePurchase, 300)); err != nil {
+		t.Fatalf("workflow error: %v", err)
+	}
+	state := w.state()
+	assertSteps(t, state, "INIT", "PENDING_CREATE", "EXPIRED")
+	if state.Deadline == nil || !state.Steps[2].At.Equal(*state.Deadline) {
+		t.Errorf("expired at %v, deadline %v", state.Steps[2].At, state.Deadline)
+	}
+	if got := w.ext.HoldStatus("ref-1"); got != fakes.HoldStatusVoided {
+		t.Errorf("hold = %s, want voided", got)
+	}
+	row := w.row("ref-1")
+	if row.Status != models.TransactionStatusFailed || row.AdditionalInfo == nil || *row.AdditionalInfo != "transaction expired" {
+		t.Errorf("row = %+v", row)
+	}
+}
+
+// TestPurchaseStartedBeforeHolds runs the DefaultVersion branches a workflow started on the
+// code before holds and the pending timeout replays into: no timer, no capture, no void.
+func TestPurchaseStartedBeforeHolds(t *testing.T) {
+	for _, signal := range []string{transaction.SignalTransactionConfirm, transaction.SignalTransactionCancel} {
+		t.Run(signal, func(t *testing.T) {
+			w := newWorkflowTest(t, 1000)
+			w.env.OnGetVersion(transaction.ChangeWalletHold, workflow.DefaultVersion, 1).Return(workflow.DefaultVersion)
+			w.env.OnGetVersion(transaction.ChangePendingTimeout, workflow.DefaultVersion, 1).Return(workflow.DefaultVersion)
+			w.env.RegisterDelayedCallback(func() {
+				w.env.SignalWorkflow(signal, transaction.SignalTransaction{})
+			}, 2*time.Hour)
+
+			if err := w.run(request("ref-1", models.TransactionTypePurchase, 300)); err != nil {
+				t.Fatalf("workflow error: %v", err)
+			}
+			state := w.state()
+			if state.Deadline != nil || steps(state)[2] == "EXPIRED" {
+				t.Errorf("state = %+v, the old code has no pending timeout", state)
+			}
+			// the fake placed a hold all the same, the old branches must leave it alone
+			if got := w.ext.HoldStatus("ref-1"); got != fakes.HoldStatusHeld {
+				t.Errorf("hold = %s, want it untouched", got)
+			}
+		})
+	}
+}
+
+func TestPurchaseInsufficientBalance(t *testing.T) {
+	w := newWorkflowTest(t, 100)
+
+	err := w.run(request("ref-1", models.TransactionTypePurchase, 300))
+	var appErr *temporal.ApplicationError
+	if !errors.As(err, &appErr) || appErr.Type() != transaction.ErrTypeInsufficientBalance {
+		t.Fatalf("workflow error = %v, want %s", err, transaction.ErrTypeInsufficientBalance)
+	}
+	state := w.state()
+	assertSteps(t, state, "INIT", "CREATE_PENDING_FAILED")
+	if state.Status != models.TransactionStatusFailed {
+		t.Errorf("state status = %s", state.Status)
+	}
+	if row := w.row("ref-1"); row.Status != models.TransactionStatusFailed {
+		t.Errorf("row status = %s, want FAILED", row.Status)
+	}
+}
+
+func TestWalletFailureAfterConfirm(t *testing.T) {
+	tests := []struct {
+		name     string
+		trxType  models.TransactionType
+		method   string
+		err      error
+		wantStep string
+	}{
+		{"credit rejected", models.TransactionTypeTopup, "CreditBalance", &external.WalletError{Kind: external.ErrUnauthorized, StatusCode: 401}, "CREDIT_FAILED"},
+		{"capture keeps failing", models.TransactionTypePurchase, "CaptureHold", &external.WalletError{Kind: external.ErrWalletTransient, StatusCode: 503}, "CAPTURE_FAILED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newWorkflowTest(t, 1000)
+			w.ext.Errors[tt.method] = tt.err
+			confirm := w.update(time.Minute, transaction.UpdateTransactionConfirm, transaction.UpdateTransaction{UserID: testUserID})
+
+			if err := w.run(request("ref-1", tt.trxType, 300)); err == nil {
+				t.Fatal("workflow succeeded")
+			}
+			state := w.state()
+			assertSteps(t, state, "INIT", "PENDING_CREATE", "CONFIRMED", tt.wantStep)
+			if state.LastError == nil {
+				t.Error("state has no last error")
+			}
+			if confirm.state.Step != tt.wantStep {
+				t.Errorf("confirm update returned step %s, want %s", confirm.state.Step, tt.wantStep)
+			}
+			// the wallet outcome is unknown, the row is left for the sweep to send to review
+			if row := w.row("ref-1"); row.Status != models.TransactionStatusPending {
+				t.Errorf("row status = %s, want PENDING", row.Status)
+			}
+		})
+	}
+}
+
+func TestNotificationFailureDoesNotFailWorkflow(t *testing.T) {
+	w := newWorkflowTest(t, 1000)
+	w.ext.Errors["NotifyTransaction"] = errors.New("notification service down")
+	w.update(time.Minute, transaction.UpdateTransactionConfirm, transaction.UpdateTransaction{UserID: testUserID})
+
+	if err := w.run(request("ref-1", models.TransactionTypePurchase, 300)); err != nil {
+		t.Fatalf("workflow error: %v", err)
+	}
+	assertSteps(t, w.state(), "INIT", "PENDING_CREATE", "CONFIRMED", "SUCCESS")
+	if row := w.row("ref-1"); row.Status != models.TransactionStatusSuccess {
+		t.Errorf("row status = %s, want SUCCESS", row.Status)
+	}
+}
+
+func TestUpdateValidation(t *testing.T) {
+	w := newWorkflowTest(t, 1000)
+	stranger := w.update(time.Minute, transaction.UpdateTransactionConfirm, transaction.UpdateTransaction{UserID: 99})
+	confirm := w.update(2*time.Minute, transaction.UpdateTransactionConfirm, transaction.UpdateTransaction{UserID: testUserID})
+	lateCancel := w.update(2*time.Minute, transaction.UpdateTransactionCancel, transaction.UpdateTransaction{UserID: testUserID})
+
+	if err := w.run(request("ref-1", models.TransactionTypeTopup, 500)); err != nil {
+		t.Fatalf("workflow error: %v", err)
+	}
+
+	assertRejected := func(name string, res *updateResult, errType string) {
+		t.Helper()
+		var appErr *temporal.ApplicationError
+		if res.accepted || !errors.As(res.rejected, &appErr) || appErr.Type() != errType {
+			t.Errorf("%s update = %+v, want rejected with %s", name, res, errType)
+		}
+	}
+	assertRejected("stranger", stranger, transaction.ErrTypeTransactionNotFound)
+	assertRejected("late cancel", lateCancel, transaction.ErrTypeTransactionNotPending)
+	if !confirm.accepted || confirm.state.Status != models.TransactionStatusSuccess {
+		t.Errorf("confirm update = %+v", confirm)
+	}
+}